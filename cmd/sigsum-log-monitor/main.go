@@ -0,0 +1,214 @@
+// Package main provides a sigsum-log-monitor binary
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"sigsum.org/log-go/internal/config"
+	"sigsum.org/log-go/internal/health"
+	"sigsum.org/log-go/internal/logging"
+	"sigsum.org/log-go/internal/metrics"
+	"sigsum.org/log-go/internal/monitor"
+	"sigsum.org/log-go/internal/systemd"
+	"sigsum.org/log-go/internal/tracing"
+	"sigsum.org/log-go/internal/version"
+
+	"sigsum.org/sigsum-go/pkg/client"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/key"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/policy"
+)
+
+// Parses the command line into c, and returns the config file, if
+// any. The monitor has its own options; those of the log servers
+// don't apply to it.
+func parseFlags(c *config.Config) string {
+	configFile := ""
+	help := false
+	versionFlag := false
+	set := getopt.New()
+	set.SetParameters("")
+	set.FlagLong(&configFile, "config-file", 0, "Config file, instead of $SIGSUM_LOGSERVER_CONFIG or /etc/sigsum/config.toml.", "file")
+	c.CommonFlags(set)
+	set.FlagLong(&c.Monitor.MetricsEndpoint, "metrics-endpoint", 0, "TCP listen port for metrics and health endpoints.", "host:port")
+	set.FlagLong(&c.Monitor.LogURL, "log-url", 0, "Public URL of the log to monitor.", "url")
+	set.FlagLong(&c.Monitor.LogPubkeyFile, "log-pubkey-file", 0, "Public key of the log to monitor.", "file")
+	set.FlagLong(&c.Monitor.PolicyFile, "policy-file", 0, "Policy, if provided, used to verify witness cosignatures.", "file")
+	set.FlagLong(&c.Monitor.WatchedKeyFiles, "watched-key-file", 0, "Public key to watch for, alerting on each leaf it signs; may be repeated.", "file")
+	set.FlagLong(&c.Monitor.WebhookURL, "webhook-url", 0, "URL to post alerts to, JSON encoded.", "url")
+	set.FlagLong(&c.Monitor.StateFile, "state-file", 0, "File where the verified state is stored, to resume after restart.", "file")
+	set.FlagLong(&c.Monitor.BatchSize, "batch-size", 0, "Number of leaves to ask for in each get-leaves request.")
+	set.FlagLong(&help, "help", '?', "Display help.")
+	set.FlagLong(&versionFlag, "version", 0, "Display monitor version.")
+	set.Parse(os.Args)
+	if help {
+		set.PrintUsage(os.Stdout)
+		os.Exit(0)
+	}
+	if versionFlag {
+		fmt.Printf("log-go version: %s\n", version.ModuleVersion())
+		os.Exit(0)
+	}
+	return configFile
+}
+
+func loadConfig(configFile string) (*config.Config, error) {
+	if configFile == "" {
+		f, err := config.OpenConfigFile()
+		if err != nil {
+			log.Info("didn't find configuration file, using defaults: %v", err)
+			return config.NewConfig(), nil
+		}
+		return config.LoadConfig(f)
+	}
+	f, err := os.Open(configFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return config.LoadConfig(f)
+}
+
+func main() {
+	// The command line is parsed twice: first to find the config
+	// file, and then to let options override the config file.
+	conf, err := loadConfig(parseFlags(config.NewConfig()))
+	if err != nil {
+		log.Fatal("failed to parse config file: %v", err)
+	}
+	parseFlags(conf)
+
+	if err := logging.Setup(conf.LogFormat, conf.LogFile, conf.LogLevel); err != nil {
+		log.Fatal("setup log output: %v", err)
+	}
+	if err := log.SetLevelFromString(conf.LogLevel); err != nil {
+		log.Fatal("setup logging: %v", err)
+	}
+	if conf.TracingEndpoint != "" {
+		shutdownTracing := tracing.Setup(conf.TracingEndpoint, "sigsum-log-monitor")
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			shutdownTracing(ctx)
+		}()
+	}
+	log.Info("log-go version: %s", version.ModuleVersion())
+
+	log.Debug("configuring log-go-monitor")
+	m, err := setupMonitorFromFlags(conf)
+	if err != nil {
+		log.Fatal("setup monitor: %v", err)
+	}
+	if conf.Interval <= 0 {
+		log.Fatal("invalid interval %v, must be positive", conf.Interval)
+	}
+
+	// Goroutines to wait for before exit.
+	var wg sync.WaitGroup
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	log.Debug("starting monitor routine")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := m.Run(ctx, conf.Interval); err != nil {
+			log.Error("monitor: %v", err)
+		}
+		log.Debug("monitor routine shutdown")
+		cancel() // must have monitor running
+	}()
+
+	internalMux := http.NewServeMux()
+	log.Debug("adding prometheus handler to internal mux, on path: /metrics")
+	internalMux.Handle("/metrics", promhttp.Handler())
+	internalMux.HandleFunc("/healthz", health.Liveness)
+	intserver := &http.Server{Addr: conf.Monitor.MetricsEndpoint, Handler: logging.Middleware(internalMux)}
+
+	listeners, err := systemd.Listeners("metrics")
+	if err != nil {
+		log.Fatal("socket activation: %v", err)
+	}
+	intListener, err := systemd.Listen(listeners, "metrics", conf.Monitor.MetricsEndpoint)
+	if err != nil {
+		log.Fatal("listen on metrics endpoint: %v", err)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		log.Info("serving metrics on %v", intListener.Addr())
+		if err := intserver.Serve(intListener); err != http.ErrServerClosed {
+			log.Error("serve(intserver): %v", err)
+		}
+		log.Debug("internal endpoints server shut down")
+		cancel()
+	}()
+	if err := systemd.Notify("READY=1"); err != nil {
+		log.Warning("%v", err)
+	}
+
+	<-ctx.Done()
+	if err := systemd.Notify("STOPPING=1"); err != nil {
+		log.Warning("%v", err)
+	}
+	log.Debug("received shutdown signal")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancelShutdown()
+	intserver.Shutdown(shutdownCtx)
+	wg.Wait()
+}
+
+// setupMonitorFromFlags() sets up a new monitor from flags.
+func setupMonitorFromFlags(conf *config.Config) (*monitor.Monitor, error) {
+	var m monitor.Monitor
+	var err error
+
+	if conf.Monitor.LogURL == "" {
+		return nil, fmt.Errorf("no log-url configured")
+	}
+	m.LogKey, err = key.ReadPublicKeyFile(conf.Monitor.LogPubkeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log pubkey: %v", err)
+	}
+	if conf.Monitor.PolicyFile != "" {
+		m.Policy, err = policy.ReadPolicyFile(conf.Monitor.PolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy file: %v", err)
+		}
+	}
+	m.WatchedKeys = make(map[crypto.Hash]crypto.PublicKey)
+	for _, file := range conf.Monitor.WatchedKeyFiles {
+		pub, err := key.ReadPublicKeyFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read watched key: %v", err)
+		}
+		m.WatchedKeys[crypto.HashBytes(pub[:])] = pub
+	}
+
+	// Don't wait forever on a stalled log or webhook.
+	httpClient := tracing.HTTPClient(logging.HTTPClient(&http.Client{Timeout: conf.Timeout}))
+	m.Log = client.New(client.Config{
+		URL:        conf.Monitor.LogURL,
+		UserAgent:  "Sigsum log-go monitor",
+		HTTPClient: httpClient,
+	})
+	m.BatchSize = conf.Monitor.BatchSize
+	m.StateFile = conf.Monitor.StateFile
+	m.Alerter = &monitor.Alerter{WebhookURL: conf.Monitor.WebhookURL, HTTPClient: httpClient}
+	m.Metrics = metrics.NewMonitorMetrics(hex.EncodeToString(m.LogKey[:]))
+	return &m, nil
+}
@@ -6,9 +6,11 @@ import (
 	"encoding/hex"
 	"fmt"
 	"html"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"sync"
 	"syscall"
 	"time"
@@ -16,12 +18,22 @@ import (
 	"github.com/pborman/getopt/v2"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"sigsum.org/log-go/internal/audit"
+	"sigsum.org/log-go/internal/cache"
 	"sigsum.org/log-go/internal/config"
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/health"
+	"sigsum.org/log-go/internal/index"
+	"sigsum.org/log-go/internal/limit"
+	"sigsum.org/log-go/internal/logging"
 	"sigsum.org/log-go/internal/metrics"
 	"sigsum.org/log-go/internal/node/primary"
+	"sigsum.org/log-go/internal/notify"
 	rateLimit "sigsum.org/log-go/internal/rate-limit"
 	"sigsum.org/log-go/internal/state"
+	"sigsum.org/log-go/internal/systemd"
+	"sigsum.org/log-go/internal/tlsconfig"
+	"sigsum.org/log-go/internal/tracing"
 	"sigsum.org/log-go/internal/version"
 
 	"sigsum.org/sigsum-go/pkg/api"
@@ -30,10 +42,24 @@ import (
 	"sigsum.org/sigsum-go/pkg/key"
 	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/server"
 	token "sigsum.org/sigsum-go/pkg/submit-token"
+	"sigsum.org/sigsum-go/pkg/types"
 )
 
+// Names of external endpoints, for which limits can be configured.
+var externalEndpoints = []string{
+	"add-leaf",
+	"get-tree-head",
+	"get-consistency-proof",
+	"get-inclusion-proof",
+	"get-leaves",
+	"wait-inclusion",
+	"get-indices-by-key-hash",
+	"get-leaves-by-checksum",
+}
+
 func ParseFlags(c *config.Config) {
 	help := false
 	versionFlag := false
@@ -45,6 +71,15 @@ func ParseFlags(c *config.Config) {
 	getopt.FlagLong(&c.Primary.SecondaryPubkeyFile, "secondary-pubkey-file", 0, "Public key for secondary node.", "file")
 	getopt.FlagLong(&c.Primary.SthFile, "sth-file", 0, "File where latest published STH is being stored.", "file")
 	getopt.FlagLong(&c.Primary.MaxRange, "max-range", 0, "Maximum number of leaves that can be retrived in a single request.")
+	getopt.FlagLong(&c.Primary.TLSCertFile, "tls-cert-file", 0, "Certificate (PEM) for serving HTTPS on the external endpoint, reloaded on SIGHUP.", "file")
+	getopt.FlagLong(&c.Primary.AuditLogFile, "audit-log-file", 0, "File recording accepted submissions, one JSON object per line.", "file")
+	getopt.FlagLong(&c.Primary.TLSKeyFile, "tls-key-file", 0, "Private key (PEM) for the external endpoint's certificate.", "file")
+	getopt.FlagLong(&c.Primary.RotateLeaves, "rotate-leaves", 0, "Rotate tree head early when this many leaves have been added, 0 to disable.")
+	getopt.FlagLong(&c.Primary.RotateDelay, "rotate-delay", 0, "Rotate tree head early, this long after the first new leaf, 0 to disable.")
+	getopt.FlagLong(&c.Primary.LeafCacheSize, "leaf-cache-size", 0, "Number of leaves cached in memory for get-leaves, 0 to disable.")
+	getopt.FlagLong(&c.Primary.LeafCacheDir, "leaf-cache-dir", 0, "Directory where complete tiles of leaves are cached.", "dir")
+	getopt.FlagLong(&c.Primary.WaitInclusionTimeout, "wait-inclusion-timeout", 0, "Maximum time a wait-inclusion request is blocked, 0 for twice the interval plus timeout.")
+	getopt.FlagLong(&c.Primary.CosignatureRefresh, "cosignature-refresh-interval", 0, "While the tree is unchanged, query witnesses only this often, 0 to query at every rotation.")
 	getopt.FlagLong(&help, "help", '?', "Display help.")
 	getopt.FlagLong(&versionFlag, "version", 0, "Display server version.")
 	getopt.Parse()
@@ -77,14 +112,20 @@ func main() {
 	conf.ServerFlags(getopt.CommandLine)
 	ParseFlags(conf)
 
-	if len(conf.LogFile) > 0 {
-		if err := log.SetLogFile(conf.LogFile); err != nil {
-			log.Fatal("open log file failed: %v", err)
-		}
+	if err := logging.Setup(conf.LogFormat, conf.LogFile, conf.LogLevel); err != nil {
+		log.Fatal("setup log output: %v", err)
 	}
 	if err := log.SetLevelFromString(conf.LogLevel); err != nil {
 		log.Fatal("setup logging: %v", err)
 	}
+	if conf.TracingEndpoint != "" {
+		shutdownTracing := tracing.Setup(conf.TracingEndpoint, "sigsum-log-primary")
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			shutdownTracing(ctx)
+		}()
+	}
 	moduleVersion := version.ModuleVersion()
 	log.Info("log-go version: %s", moduleVersion)
 
@@ -99,23 +140,72 @@ func main() {
 		log.Fatal("setup primary: %v", err)
 	}
 
-	// wait for clean-up before exit
+	// Goroutines to wait for before exit.
 	var wg sync.WaitGroup
-	defer wg.Wait()
 
-	// Makes signal cancel state manager, and trigger server shutdown below.
+	// Makes signal trigger the ordered shutdown below.
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	// The state manager is stopped separately, after the external
+	// server, so that a rotation that is in progress can complete
+	// and its cosigned tree head be stored. No further rotation is
+	// started; leaves accepted since the latest rotation are
+	// published after restart.
+	stateCtx, stopState := context.WithCancel(context.Background())
+	defer stopState()
+	stateDone := make(chan struct{})
+
 	log.Debug("starting primary state manager routine")
-	wg.Add(1)
 	go func() {
-		defer wg.Done()
-		node.Stateman.Run(ctx, witnesses, conf.Interval)
+		defer close(stateDone)
+		node.Stateman.Run(stateCtx, witnesses, conf.Interval)
 		log.Debug("state manager shutdown")
 		cancel() // must have state manager running
 	}()
 
+	backend := node.DbClient
+	if leafCache, ok := backend.(*db.LeafCache); ok {
+		backend = leafCache.Client
+	}
+	if trillianClient, ok := backend.(*db.TrillianClient); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			trillianClient.WatchConnection(ctx)
+		}()
+		log.Debug("starting sequenced leaf indexer")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.IndexSequenced(ctx, trillianClient.IndexSequenced)
+		}()
+	}
+
+	log.Debug("starting backend tree size watcher")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		node.WatchBackendSize(stateCtx)
+	}()
+
+	// Indexes are bound to the Trillian tree; the ephemeral
+	// backend uses tree ID 0.
+	var treeID int64
+	if trillianClient, ok := backend.(*db.TrillianClient); ok {
+		treeID = trillianClient.TreeID()
+	}
+	indexes, err := index.OpenSet(conf.KeyHashIndexFile, conf.ChecksumIndexFile, treeID)
+	if err != nil {
+		log.Fatal("open indexes: %v", err)
+	}
+	log.Debug("starting indexer")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		indexes.Run(ctx, node.DbClient)
+	}()
+
 	externalMux := http.NewServeMux()
 	// Register HTTP endpoints.
 	log.Debug("adding external handler under prefix: %s", conf.Prefix)
@@ -126,11 +216,22 @@ func main() {
 	} else {
 		pattern = "/" + conf.Prefix + "/"
 	}
-	externalMux.Handle(pattern, server.NewLog(&server.Config{
+	serverMetrics := metrics.NewServerMetrics(hex.EncodeToString(publicKey[:]))
+	logHandler := server.NewLog(&server.Config{
 		Prefix:  conf.Prefix,
 		Timeout: conf.Timeout,
-		Metrics: metrics.NewServerMetrics(hex.EncodeToString(publicKey[:])),
-	}, node))
+		Metrics: serverMetrics,
+	}, node)
+	externalMux.Handle(pattern, logHandler)
+	// Proofs are for fixed tree sizes, and never change.
+	externalMux.Handle("GET "+pattern+"get-consistency-proof/", cache.Immutable(logHandler))
+	externalMux.Handle("GET "+pattern+"get-inclusion-proof/", cache.Immutable(logHandler))
+	externalMux.Handle("GET "+pattern+"wait-inclusion/{hash}",
+		metrics.Handler(serverMetrics, "wait-inclusion", http.HandlerFunc(node.WaitInclusion)))
+	// Lookups are limited to the published tree.
+	indexes.Register(externalMux, pattern, func(context.Context) (uint64, error) {
+		return node.Stateman.CosignedTreeHead().Size, nil
+	}, node.DbClient)
 
 	infoPage := []byte(fmt.Sprintf(`
 <!DOCTYPE html>
@@ -154,7 +255,36 @@ func main() {
 			http.Redirect(w, r, conf.Prefix+"/", http.StatusMovedPermanently)
 		})
 	}
-	extserver := &http.Server{Addr: conf.ExternalEndpoint, Handler: externalMux}
+	limits := conf.Limits()
+	for name := range limits {
+		if !slices.Contains(externalEndpoints, name) {
+			log.Fatal("endpoint-limits: unknown endpoint %q", name)
+		}
+	}
+	extHandler := limit.Middleware(conf.Prefix, limits, externalMux)
+	extserver := &http.Server{Addr: conf.ExternalEndpoint, Handler: logging.Middleware(tracing.Middleware(audit.Middleware(extHandler)))}
+	if conf.Primary.TLSCertFile != "" {
+		certReloader, err := tlsconfig.NewCertReloader(conf.Primary.TLSCertFile, conf.Primary.TLSKeyFile)
+		if err != nil {
+			log.Fatal("setup external TLS: %v", err)
+		}
+		extserver.TLSConfig = certReloader.ServerConfig()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			certReloader.ReloadOnSignal(ctx, syscall.SIGHUP)
+		}()
+	}
+
+	// The state manager is set up with a signed tree head, so
+	// we're ready when the backend is reachable.
+	backendCheck := func(ctx context.Context) error {
+		_, err := node.DbClient.GetTreeHead(ctx)
+		return err
+	}
+	if trillianClient, ok := backend.(*db.TrillianClient); ok {
+		backendCheck = trillianClient.CheckHealth
+	}
 
 	internalMux := http.NewServeMux()
 	log.Debug("adding internal handler under prefix: %s", conf.Prefix)
@@ -166,16 +296,51 @@ func main() {
 		// external endpoint.
 	},
 		node.GetLeavesInternal))
+	internalMux.HandleFunc("GET "+pattern+"get-tree-head", node.GetTreeHeadInternal)
+	internalMux.HandleFunc("GET "+pattern+"wait-tree-size/{size}", node.WaitTreeSizeInternal)
 
 	log.Debug("adding prometheus handler to internal mux, on path: /metrics")
 	internalMux.Handle("/metrics", promhttp.Handler())
-	intserver := &http.Server{Addr: conf.InternalEndpoint, Handler: internalMux}
+	log.Debug("adding health handlers to internal mux, on paths: /healthz, /readyz")
+	internalMux.HandleFunc("/healthz", health.Liveness)
+	internalMux.Handle("/readyz", health.Readiness(
+		health.Check{Name: "backend", Check: backendCheck},
+		health.Check{Name: "state", Check: func(context.Context) error { return node.Stateman.Ready() }}))
+	intTLSConfig, err := conf.InternalTLS().ServerConfig()
+	if err != nil {
+		log.Fatal("setup internal TLS: %v", err)
+	}
+	intserver := &http.Server{Addr: conf.InternalEndpoint, Handler: logging.Middleware(tracing.Middleware(internalMux)), TLSConfig: intTLSConfig}
+	// Cancelled when shutting down the internal server, to
+	// terminate long-poll requests.
+	internalCtx, stopInternal := context.WithCancel(context.Background())
+	defer stopInternal()
+	intserver.BaseContext = func(net.Listener) context.Context { return internalCtx }
+
+	listeners, err := systemd.Listeners("internal", "external")
+	if err != nil {
+		log.Fatal("socket activation: %v", err)
+	}
+	intListener, err := systemd.Listen(listeners, "internal", conf.InternalEndpoint)
+	if err != nil {
+		log.Fatal("listen on internal endpoint: %v", err)
+	}
+	extListener, err := systemd.Listen(listeners, "external", conf.ExternalEndpoint)
+	if err != nil {
+		log.Fatal("listen on external endpoint: %v", err)
+	}
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		log.Info("serving log nodes on %v/%v", conf.InternalEndpoint, conf.Prefix)
-		if err = intserver.ListenAndServe(); err != http.ErrServerClosed {
+		log.Info("serving log nodes on %v/%v", intListener.Addr(), conf.Prefix)
+		if intTLSConfig != nil {
+			// Certificates are already loaded into TLSConfig.
+			err = intserver.ServeTLS(intListener, "", "")
+		} else {
+			err = intserver.Serve(intListener)
+		}
+		if err != http.ErrServerClosed {
 			log.Error("serve(intserver): %v", err)
 		}
 		log.Debug("internal endpoints server shut down")
@@ -185,24 +350,65 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		log.Info("serving clients on %v/%v", conf.ExternalEndpoint, conf.Prefix)
-		if err = extserver.ListenAndServe(); err != http.ErrServerClosed {
+		log.Info("serving clients on %v/%v", extListener.Addr(), conf.Prefix)
+		if extserver.TLSConfig != nil {
+			// Certificate provided by TLSConfig.GetCertificate.
+			err = extserver.ServeTLS(extListener, "", "")
+		} else {
+			err = extserver.Serve(extListener)
+		}
+		if err != http.ErrServerClosed {
 			log.Error("serve(server): %v", err)
 		}
 		log.Debug("public endpoints server shut down")
 		cancel()
 	}()
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		systemd.NotifyReady(ctx, backendCheck)
+		systemd.Watchdog(ctx, backendCheck)
+	}()
+
 	<-ctx.Done()
+	if err := systemd.Notify("STOPPING=1"); err != nil {
+		log.Warning("%v", err)
+	}
 	log.Debug("received shutdown signal")
 
-	shutdownCtx, _ := context.WithTimeout(context.Background(), time.Second*60)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancelShutdown()
+	// Stop accepting submissions, and wait for ongoing requests.
 	log.Info("stopping http server, please wait...")
 	extserver.Shutdown(shutdownCtx)
 	log.Info("... done")
+	// Any ongoing rotation is completed, and the resulting
+	// cosigned tree head is stored, before the state manager
+	// returns.
+	log.Info("stopping state manager, please wait...")
+	stopState()
+	<-stateDone
+	log.Info("... done")
+	// Kept running until now, so that the secondary can replicate
+	// all leaves during the final rotation.
 	log.Info("stopping internal api server, please wait...")
+	stopInternal()
 	intserver.Shutdown(shutdownCtx)
 	log.Info("... done")
+
+	wg.Wait()
+	if err := indexes.Close(); err != nil {
+		log.Error("closing indexes failed: %v", err)
+	}
+	if node.AuditLog != nil {
+		if err := node.AuditLog.Close(); err != nil {
+			log.Error("closing audit log failed: %v", err)
+		}
+	}
+	if err := node.DbClient.Close(); err != nil {
+		log.Error("closing backend connection failed: %v", err)
+	}
 }
 
 // setupPrimaryFromFlags() sets up a new sigsum primary node from flags.
@@ -216,6 +422,15 @@ func setupPrimaryFromFlags(conf *config.Config) (*primary.Primary, crypto.Public
 	}
 	publicKey := signer.Public()
 	p.MaxRange = conf.MaxRange
+	p.BackendSize = &notify.Size{}
+	p.WaitInclusionTimeout = conf.Primary.WaitInclusionTimeout
+	if p.WaitInclusionTimeout == 0 {
+		// Time for the next rotation to start, and to
+		// complete.
+		p.WaitInclusionTimeout = 2*conf.Interval + conf.Timeout
+	}
+	p.ConsistencyProofs = cache.NewLRU[requests.ConsistencyProof, types.ConsistencyProof](conf.Primary.ProofCacheSize)
+	p.InclusionProofs = cache.NewLRU[requests.InclusionProof, types.InclusionProof](conf.Primary.ProofCacheSize)
 
 	switch conf.Backend {
 	default:
@@ -223,12 +438,20 @@ func setupPrimaryFromFlags(conf *config.Config) (*primary.Primary, crypto.Public
 	case "ephemeral":
 		p.DbClient = db.NewMemoryDb()
 	case "trillian":
-		trillianClient, err := db.DialTrillian(conf.TrillianRpcServer, conf.Timeout, db.PrimaryTree, conf.TrillianTreeIDFile)
+		tlsConf, err := conf.TrillianTLS().ClientConfig()
+		if err != nil {
+			return nil, crypto.PublicKey{}, err
+		}
+		trillianClient, err := db.DialTrillian(conf.TrillianRpcServer, conf.Timeout, db.PrimaryTree, conf.TrillianTreeIDFile, tlsConf)
 		if err != nil {
 			return nil, crypto.PublicKey{}, err
 		}
 		p.DbClient = trillianClient
 	}
+	if conf.Primary.LeafCacheSize > 0 || conf.Primary.LeafCacheDir != "" {
+		p.DbClient = db.NewLeafCache(p.DbClient, conf.Primary.LeafCacheSize, conf.Primary.LeafCacheDir,
+			metrics.NewLeafCacheMetrics(hex.EncodeToString(publicKey[:])))
+	}
 	// Setup secondary node configuration.
 	var secondary api.Secondary
 	var secondaryPub crypto.PublicKey
@@ -238,15 +461,43 @@ func setupPrimaryFromFlags(conf *config.Config) (*primary.Primary, crypto.Public
 		if err != nil {
 			return nil, crypto.PublicKey{}, fmt.Errorf("failed to read secondary node pubkey: %v", err)
 		}
-		secondary = client.New(client.Config{URL: conf.Primary.SecondaryURL})
+		httpClient, err := conf.InternalTLS().HTTPClient()
+		if err != nil {
+			return nil, crypto.PublicKey{}, err
+		}
+		if httpClient == nil {
+			httpClient = &http.Client{}
+		}
+		// Don't wait forever on a stalled secondary.
+		httpClient.Timeout = conf.Timeout
+		httpClient = tracing.HTTPClient(logging.HTTPClient(httpClient))
+		secondary = client.New(client.Config{URL: conf.Primary.SecondaryURL, HTTPClient: httpClient})
 	}
 
 	// Setup state manager.
-	p.Stateman, err = state.NewStateManagerSingle(p.DbClient, signer, conf.Timeout,
+	stateman, err := state.NewStateManagerSingle(p.DbClient, signer, conf.Timeout,
 		secondary, &secondaryPub, conf.Primary.SthFile)
 	if err != nil {
 		return nil, crypto.PublicKey{}, fmt.Errorf("NewStateManagerSingle: %v", err)
 	}
+	if conf.Primary.RotateLeaves < 0 {
+		return nil, crypto.PublicKey{}, fmt.Errorf("invalid rotate-leaves %d, must be non-negative", conf.Primary.RotateLeaves)
+	}
+	stateman.Trigger = state.RotationTrigger{
+		BackendSize: p.BackendSize,
+		Leaves:      uint64(conf.Primary.RotateLeaves),
+		Delay:       conf.Primary.RotateDelay,
+	}
+	stateman.CosignatureRefresh = conf.Primary.CosignatureRefresh
+	p.Stateman = stateman
+
+	if conf.Primary.AuditLogFile != "" {
+		p.AuditLog, err = audit.Open(conf.Primary.AuditLogFile,
+			conf.Primary.AuditLogMaxSize, conf.Primary.AuditLogMaxFiles)
+		if err != nil {
+			return nil, crypto.PublicKey{}, err
+		}
+	}
 
 	p.TokenVerifier = token.NewDnsVerifier(&publicKey)
 	if len(conf.Primary.RateLimitFile) > 0 {
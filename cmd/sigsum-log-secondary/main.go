@@ -17,8 +17,15 @@ import (
 
 	"sigsum.org/log-go/internal/config"
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/health"
+	"sigsum.org/log-go/internal/index"
+	"sigsum.org/log-go/internal/limit"
+	"sigsum.org/log-go/internal/logging"
 	"sigsum.org/log-go/internal/metrics"
 	"sigsum.org/log-go/internal/node/secondary"
+	"sigsum.org/log-go/internal/state"
+	"sigsum.org/log-go/internal/systemd"
+	"sigsum.org/log-go/internal/tracing"
 	"sigsum.org/log-go/internal/version"
 
 	"sigsum.org/sigsum-go/pkg/client"
@@ -33,6 +40,11 @@ func ParseFlags(c *config.Config) {
 	versionFlag := false
 	getopt.SetParameters("")
 	getopt.FlagLong(&c.Secondary.PrimaryURL, "primary-url", 0, "Primary node endpoint for fetching leaves.", "url")
+	getopt.FlagLong(&c.Secondary.PrimaryPubkeyFile, "primary-pubkey-file", 0, "Public key of the log, used to verify the primary's tree head against replicated leaves.", "file")
+	getopt.FlagLong(&c.Secondary.BatchSize, "batch-size", 0, "Number of leaves to ask for in each request to the primary.")
+	getopt.FlagLong(&c.Secondary.ParallelFetches, "parallel-fetches", 0, "Maximum number of concurrent requests to the primary while catching up.")
+	getopt.FlagLong(&c.Secondary.Mirror, "mirror", 0, "Serve the public read endpoints, as of the primary's latest verified tree head.")
+	getopt.FlagLong(&c.Primary.MaxRange, "max-range", 0, "Maximum number of leaves that can be retrieved in a single request, when running as a mirror.")
 	getopt.FlagLong(&help, "help", '?', "Display help.")
 	getopt.FlagLong(&versionFlag, "version", 0, "Display server version.")
 	getopt.Parse()
@@ -65,14 +77,20 @@ func main() {
 	conf.ServerFlags(getopt.CommandLine)
 	ParseFlags(conf)
 
-	if len(conf.LogFile) > 0 {
-		if err := log.SetLogFile(conf.LogFile); err != nil {
-			log.Fatal("open log file failed: %v", err)
-		}
+	if err := logging.Setup(conf.LogFormat, conf.LogFile, conf.LogLevel); err != nil {
+		log.Fatal("setup log output: %v", err)
 	}
 	if err := log.SetLevelFromString(conf.LogLevel); err != nil {
 		log.Fatal("setup logging: %v", err)
 	}
+	if conf.TracingEndpoint != "" {
+		shutdownTracing := tracing.Setup(conf.TracingEndpoint, "sigsum-log-secondary")
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			shutdownTracing(ctx)
+		}()
+	}
 	log.Info("log-go version: %s", version.ModuleVersion())
 
 	log.Debug("configuring log-go-secondary")
@@ -81,13 +99,20 @@ func main() {
 		log.Fatal("setup secondary: %v", err)
 	}
 
-	// wait for clean-up before exit
+	// Goroutines to wait for before exit.
 	var wg sync.WaitGroup
-	defer wg.Wait()
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	if trillianClient, ok := node.DbClient.(*db.TrillianClient); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			trillianClient.WatchConnection(ctx)
+		}()
+	}
+
 	log.Debug("starting periodic routine")
 	wg.Add(1)
 	go func() {
@@ -97,24 +122,106 @@ func main() {
 		cancel() // must have periodic running
 	}()
 
-	// No external endpoints but we want to return 404.
-	extserver := &http.Server{Addr: conf.ExternalEndpoint, Handler: http.NewServeMux()}
+	// Both internal and, when running as a mirror, external
+	// endpoints are counted by the same metrics.
+	serverMetrics := metrics.NewServerMetrics(hex.EncodeToString(publicKey[:]))
+
+	// Without mirroring, no external endpoints, but we want to
+	// return 404.
+	externalMux := http.NewServeMux()
+	if node.Mirror != nil {
+		externalMux.Handle("/", server.NewLog(&server.Config{
+			Prefix:  conf.Prefix,
+			Timeout: conf.Timeout,
+			Metrics: serverMetrics,
+		}, node.Mirror))
+	}
+	// Indexes are bound to the Trillian tree; the ephemeral
+	// backend uses tree ID 0.
+	var treeID int64
+	if trillianClient, ok := node.DbClient.(*db.TrillianClient); ok {
+		treeID = trillianClient.TreeID()
+	}
+	indexes, err := index.OpenSet(conf.KeyHashIndexFile, conf.ChecksumIndexFile, treeID)
+	if err != nil {
+		log.Fatal("open indexes: %v", err)
+	}
+	defer indexes.Close()
+	log.Debug("starting indexer")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		indexes.Run(ctx, node.DbClient)
+	}()
+	pattern := "/"
+	if conf.Prefix != "" {
+		pattern = "/" + conf.Prefix + "/"
+	}
+	// Lookups are served only by a mirror, limited to the
+	// primary's latest verified tree head, since other replicated
+	// leaves may not yet be published. Otherwise, the indexes are
+	// still kept up to date, to be ready if the secondary is
+	// promoted.
+	if node.Mirror != nil {
+		indexes.Register(externalMux, pattern, func(context.Context) (uint64, error) {
+			return node.Mirror.TreeSize(), nil
+		}, node.DbClient)
+	}
+	// A mirror applies the same limits as the primary.
+	extHandler := limit.Middleware(conf.Prefix, conf.Limits(), externalMux)
+	extserver := &http.Server{Addr: conf.ExternalEndpoint, Handler: logging.Middleware(tracing.Middleware(extHandler))}
+	// Ready when the backend is reachable.
+	backendCheck := func(ctx context.Context) error {
+		_, err := node.DbClient.GetTreeHead(ctx)
+		return err
+	}
+	if trillianClient, ok := node.DbClient.(*db.TrillianClient); ok {
+		backendCheck = trillianClient.CheckHealth
+	}
+
 	// Register HTTP endpoints.
 	internalMux := http.NewServeMux()
 	internalMux.Handle("/", server.NewSecondary(&server.Config{
 		Prefix:  conf.Prefix,
 		Timeout: conf.Timeout,
-		Metrics: metrics.NewServerMetrics(hex.EncodeToString(publicKey[:])),
+		Metrics: serverMetrics,
 	}, node))
 	log.Debug("adding prometheus handler to internal mux, on path: /metrics")
 	internalMux.Handle("/metrics", promhttp.Handler())
-	intserver := &http.Server{Addr: conf.InternalEndpoint, Handler: internalMux}
+	log.Debug("adding health handlers to internal mux, on paths: /healthz, /readyz")
+	internalMux.HandleFunc("/healthz", health.Liveness)
+	internalMux.Handle("/readyz", health.Readiness(
+		health.Check{Name: "backend", Check: backendCheck}))
+	intTLSConfig, err := conf.InternalTLS().ServerConfig()
+	if err != nil {
+		log.Fatal("setup internal TLS: %v", err)
+	}
+	intserver := &http.Server{Addr: conf.InternalEndpoint, Handler: logging.Middleware(tracing.Middleware(internalMux)), TLSConfig: intTLSConfig}
+
+	listeners, err := systemd.Listeners("internal", "external")
+	if err != nil {
+		log.Fatal("socket activation: %v", err)
+	}
+	intListener, err := systemd.Listen(listeners, "internal", conf.InternalEndpoint)
+	if err != nil {
+		log.Fatal("listen on internal endpoint: %v", err)
+	}
+	extListener, err := systemd.Listen(listeners, "external", conf.ExternalEndpoint)
+	if err != nil {
+		log.Fatal("listen on external endpoint: %v", err)
+	}
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		log.Info("serving log nodes on %v/%v", conf.InternalEndpoint, conf.Prefix)
-		if err = intserver.ListenAndServe(); err != http.ErrServerClosed {
+		log.Info("serving log nodes on %v/%v", intListener.Addr(), conf.Prefix)
+		if intTLSConfig != nil {
+			// Certificates are already loaded into TLSConfig.
+			err = intserver.ServeTLS(intListener, "", "")
+		} else {
+			err = intserver.Serve(intListener)
+		}
+		if err != http.ErrServerClosed {
 			log.Error("serve(intserver): %v", err)
 		}
 		log.Debug("internal endpoints server shut down")
@@ -124,18 +231,28 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		log.Info("serving clients on %v/%v", conf.ExternalEndpoint, conf.Prefix)
-		if err = extserver.ListenAndServe(); err != http.ErrServerClosed {
+		log.Info("serving clients on %v/%v", extListener.Addr(), conf.Prefix)
+		if err = extserver.Serve(extListener); err != http.ErrServerClosed {
 			log.Error("serve(server): %v", err)
 		}
 		log.Debug("public endpoints server shut down")
 		cancel()
 	}()
 
-	<-ctx.Done()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		systemd.NotifyReady(ctx, backendCheck)
+		systemd.Watchdog(ctx, backendCheck)
+	}()
 
+	<-ctx.Done()
+	if err := systemd.Notify("STOPPING=1"); err != nil {
+		log.Warning("%v", err)
+	}
 	log.Debug("received shutdown signal")
-	shutdownCtx, _ := context.WithTimeout(context.Background(), time.Second*60)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancelShutdown()
 
 	log.Info("stopping http server, please wait...")
 	extserver.Shutdown(shutdownCtx)
@@ -143,6 +260,12 @@ func main() {
 	log.Info("stopping internal api server, please wait...")
 	intserver.Shutdown(shutdownCtx)
 	log.Info("... done")
+
+	// Wait for replication to stop before closing the backend.
+	wg.Wait()
+	if err := node.DbClient.Close(); err != nil {
+		log.Error("closing backend connection failed: %v", err)
+	}
 }
 
 // setupSecondaryFromFlags() sets up a new sigsum secondary node from flags.
@@ -157,6 +280,8 @@ func setupSecondaryFromFlags(conf *config.Config) (*secondary.Secondary, crypto.
 	}
 
 	s.Interval = conf.Interval
+	s.BatchSize = conf.Secondary.BatchSize
+	s.ParallelFetches = conf.Secondary.ParallelFetches
 
 	switch conf.Backend {
 	default:
@@ -164,14 +289,51 @@ func setupSecondaryFromFlags(conf *config.Config) (*secondary.Secondary, crypto.
 	case "ephemeral":
 		s.DbClient = db.NewMemoryDb()
 	case "trillian":
-		trillianClient, err := db.DialTrillian(conf.TrillianRpcServer, conf.Timeout, db.SecondaryTree, conf.TrillianTreeIDFile)
+		tlsConf, err := conf.TrillianTLS().ClientConfig()
+		if err != nil {
+			return nil, crypto.PublicKey{}, err
+		}
+		trillianClient, err := db.DialTrillian(conf.TrillianRpcServer, conf.Timeout, db.SecondaryTree, conf.TrillianTreeIDFile, tlsConf)
 		if err != nil {
 			return nil, crypto.PublicKey{}, err
 		}
 		s.DbClient = trillianClient
 	}
 	// Setup primary node configuration.
-	s.Primary = client.New(client.Config{URL: conf.Secondary.PrimaryURL})
+	httpClient, err := conf.InternalTLS().HTTPClient()
+	if err != nil {
+		return nil, crypto.PublicKey{}, err
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	// Don't wait forever on a stalled primary. The long-poll
+	// waiter uses its own timeout.
+	httpClient.Timeout = conf.Timeout
+	httpClient = tracing.HTTPClient(logging.HTTPClient(httpClient))
+	s.Primary = client.New(client.Config{URL: conf.Secondary.PrimaryURL, HTTPClient: httpClient})
+	if conf.Secondary.LongPoll {
+		s.Waiter = secondary.NewTreeSizeWaiter(conf.Secondary.PrimaryURL, httpClient)
+	}
+	if conf.Secondary.PrimaryPubkeyFile != "" {
+		primaryPub, err := key.ReadPublicKeyFile(conf.Secondary.PrimaryPubkeyFile)
+		if err != nil {
+			return nil, crypto.PublicKey{}, fmt.Errorf("failed to read primary node pubkey: %v", err)
+		}
+		s.PrimaryPub = &primaryPub
+		// Where a primary expects its latest cosigned tree
+		// head, so that it's available if this node is
+		// promoted. Also lets a mirror serve reads after a
+		// restart, even if the primary is down.
+		s.CosignedFile = conf.SthFile + state.CosignedFileSuffix
+	}
+	if conf.Secondary.Mirror {
+		// Serving tree heads requires verifying them first.
+		if s.PrimaryPub == nil {
+			return nil, crypto.PublicKey{}, fmt.Errorf("mirror mode requires a primary-pubkey-file")
+		}
+		s.Mirror = &secondary.Mirror{MaxRange: conf.MaxRange, DbClient: s.DbClient}
+	}
 
 	return &s, s.Signer.Public(), nil
 }
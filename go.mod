@@ -12,6 +12,7 @@ require (
 	github.com/google/trillian v1.7.1
 	github.com/pborman/getopt/v2 v2.1.0
 	github.com/prometheus/client_golang v1.20.5
+	golang.org/x/sys v0.29.0
 	google.golang.org/grpc v1.69.4
 	sigsum.org/sigsum-go v0.10.1
 )
@@ -93,7 +94,6 @@ require (
 	golang.org/x/net v0.34.0 // indirect
 	golang.org/x/oauth2 v0.24.0 // indirect
 	golang.org/x/sync v0.10.0 // indirect
-	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 	golang.org/x/time v0.8.0 // indirect
 	golang.org/x/tools v0.29.0 // indirect
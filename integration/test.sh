@@ -62,6 +62,7 @@ EOF
 
 	# Secondary
 	nvars[$logb:ssrv_extra_args]="--primary-url=http://${nvars[$loga:int_url]}"
+	nvars[$logb:ssrv_extra_args]+=" --primary-pubkey-file=${nvars[$loga:log_dir]}/ssrv.key.pub"
 	if [[ "$testflavor" = ephemeral ]] ; then
 		nvars[$logb:ssrv_extra_args]+=" --backend ephemeral"
 	fi
@@ -94,6 +95,7 @@ EOF
 		node_start_fe $logb
 
 		nvars[$logc:ssrv_extra_args]="--primary-url=http://${nvars[$logb:int_url]}"
+		nvars[$logc:ssrv_extra_args]+=" --primary-pubkey-file=${nvars[$logb:log_dir]}/ssrv.key.pub"
 		nodes+=" logc"
 		node_start $logc
 
@@ -0,0 +1,151 @@
+// Package audit implements an append-only log of accepted
+// submissions, one JSON object per line, with size-based rotation.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record describes an accepted add-leaf request.
+type Record struct {
+	Time            time.Time `json:"time"`
+	LeafHash        string    `json:"leaf_hash"`
+	KeyHash         string    `json:"key_hash"`
+	Domain          string    `json:"domain,omitempty"`
+	ClientAddr      string    `json:"client_addr,omitempty"`
+	RateLimitBucket string    `json:"rate_limit_bucket,omitempty"`
+}
+
+// Log is an append-only audit log. When the file exceeds maxSize
+// bytes, it is renamed by appending ".1" to its name, any previously
+// rotated files are renamed from ".N" to ".N+1", and files beyond
+// maxFiles are deleted.
+type Log struct {
+	name     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Open opens, or creates, the audit log file. If maxSize is zero,
+// the file is never rotated. Otherwise, at least one old file must be
+// kept, since the records would be lost.
+func Open(name string, maxSize int64, maxFiles int) (*Log, error) {
+	if maxSize > 0 && maxFiles < 1 {
+		return nil, fmt.Errorf("invalid audit log max files %d, must be at least 1 when rotating", maxFiles)
+	}
+	l := Log{name: name, maxSize: maxSize, maxFiles: maxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed opening audit log: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed opening audit log: %v", err)
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// Rotates the files, and reopens the log. If renaming fails, the
+// current file is reopened, so that records are still written.
+func (l *Log) rotate() error {
+	err := l.f.Close()
+	l.f = nil
+	if err == nil {
+		err = l.renameFiles()
+	}
+	if openErr := l.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (l *Log) renameFiles() error {
+	os.Remove(fmt.Sprintf("%s.%d", l.name, l.maxFiles))
+	for i := l.maxFiles - 1; i > 0; i-- {
+		old := fmt.Sprintf("%s.%d", l.name, i)
+		if err := os.Rename(old, fmt.Sprintf("%s.%d", l.name, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.name, l.name+".1")
+}
+
+// Write appends a record to the log. If rotation fails, the record
+// is still written, if possible, and the rotation error is returned.
+func (l *Log) Write(r *Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var rotateErr error
+	if l.f == nil {
+		// Reopening failed at a previous rotation.
+		if err := l.open(); err != nil {
+			return err
+		}
+	} else if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			if l.f == nil {
+				return fmt.Errorf("failed rotating audit log: %v", err)
+			}
+			rotateErr = fmt.Errorf("failed rotating audit log: %v", err)
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+type clientAddrKey struct{}
+
+// ClientAddr returns the client address recorded by Middleware, or ""
+// if none.
+func ClientAddr(ctx context.Context) string {
+	addr, _ := ctx.Value(clientAddrKey{}).(string)
+	return addr
+}
+
+// Middleware records the client's address in the request context,
+// for use by request handlers that don't have access to the
+// http.Request.
+func Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientAddrKey{}, r.RemoteAddr)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
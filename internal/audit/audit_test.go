@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readRecords(t *testing.T, name string) []Record {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []Record
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid audit log line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestWriteAndRotate(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.log")
+	record := func(i int) *Record {
+		return &Record{
+			Time:     time.Unix(int64(i), 0).UTC(),
+			LeafHash: fmt.Sprintf("%064x", i),
+			KeyHash:  fmt.Sprintf("%064x", 0),
+		}
+	}
+	line, err := json.Marshal(record(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Room for three records per file.
+	l, err := Open(name, int64(3*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := l.Write(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []struct {
+		file  string
+		first int
+		count int
+	}{
+		{name, 9, 1},
+		{name + ".1", 6, 3},
+		{name + ".2", 3, 3},
+	} {
+		records := readRecords(t, table.file)
+		if len(records) != table.count {
+			t.Errorf("%s: unexpected number of records, got %d, wanted %d", table.file, len(records), table.count)
+			continue
+		}
+		for i, r := range records {
+			if r != *record(table.first + i) {
+				t.Errorf("%s: unexpected record %d: %v", table.file, i, r)
+			}
+		}
+	}
+	if _, err := os.Stat(name + ".3"); !os.IsNotExist(err) {
+		t.Errorf("too many rotated files kept: %v", err)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.log")
+	if _, err := Open(name, 1000, 0); err == nil {
+		t.Errorf("rotation without keeping old files not rejected")
+	}
+	l, err := Open(name, 0, 0)
+	if err != nil {
+		t.Fatalf("open without rotation failed: %v", err)
+	}
+	l.Close()
+}
+
+func TestRotateFailure(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.log")
+	// Makes renaming the log file fail.
+	if err := os.MkdirAll(filepath.Join(name+".1", "x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Open(name, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := 0; i < 3; i++ {
+		err := l.Write(&Record{LeafHash: fmt.Sprint(i)})
+		// Rotation is attempted for all but the first record.
+		if got, want := err != nil, i > 0; got != want {
+			t.Errorf("record %d: unexpected result, got error %v, wanted error %v", i, err, want)
+		}
+	}
+	if got := readRecords(t, name); len(got) != 3 {
+		t.Errorf("unexpected records after failed rotation: %v", got)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var got string
+	h := Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = ClientAddr(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/add-leaf", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if want := "192.0.2.1:1234"; got != want {
+		t.Errorf("unexpected client address, got %q, wanted %q", got, want)
+	}
+	if addr := ClientAddr(context.Background()); addr != "" {
+		t.Errorf("unexpected client address without middleware: %q", addr)
+	}
+}
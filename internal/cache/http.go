@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Cache-Control value for responses that never change.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// Returns an ETag for the given path. Responses are derived from the
+// path alone, so the ETag can be checked without producing the
+// response.
+func etag(path string) string {
+	h := sha256.Sum256([]byte(path))
+	return `"` + hex.EncodeToString(h[:16]) + `"`
+}
+
+func matchETag(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+type immutableWriter struct {
+	http.ResponseWriter
+	tag         string
+	wroteHeader bool
+}
+
+func (w *immutableWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		// Errors, e.g., for sizes beyond the current tree,
+		// may change and are not cacheable.
+		if status == http.StatusOK {
+			w.Header().Set("Cache-Control", immutableCacheControl)
+			w.Header().Set("ETag", w.tag)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *immutableWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Immutable wraps a handler whose successful responses depend only on
+// the request path, e.g., proofs for fixed tree sizes. Successful
+// responses get headers letting clients and proxies cache them
+// indefinitely, with an ETag derived from the path. A request with a
+// matching If-None-Match header gets a 304 Not Modified response,
+// without invoking the handler.
+func Immutable(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tag := etag(r.URL.Path)
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if inm := r.Header.Get("If-None-Match"); inm != "" && matchETag(inm, tag) {
+				w.Header().Set("Cache-Control", immutableCacheControl)
+				w.Header().Set("ETag", tag)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		h.ServeHTTP(&immutableWriter{ResponseWriter: w, tag: tag}, r)
+	})
+}
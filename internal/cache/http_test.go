@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestImmutable(t *testing.T) {
+	calls := 0
+	h := Immutable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("proof"))
+	}))
+
+	get := func(path, inm string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/proof", "")
+	if w.Code != http.StatusOK || w.Body.String() != "proof" {
+		t.Fatalf("unexpected response: %d %q", w.Code, w.Body.String())
+	}
+	tag := w.Header().Get("ETag")
+	if tag == "" || w.Header().Get("Cache-Control") != immutableCacheControl {
+		t.Errorf("missing cache headers: %v", w.Header())
+	}
+
+	w = get("/proof", `"other", `+tag)
+	if w.Code != http.StatusNotModified || calls != 1 {
+		t.Errorf("expected 304 without calling handler, got %d, calls %d", w.Code, calls)
+	}
+	w = get("/proof", `"other"`)
+	if w.Code != http.StatusOK || calls != 2 {
+		t.Errorf("expected 200 for non-matching ETag, got %d, calls %d", w.Code, calls)
+	}
+	w = get("/other-proof", tag)
+	if w.Code != http.StatusOK {
+		t.Errorf("ETag matched for a different path")
+	}
+
+	w = get("/missing", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+	if w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "" {
+		t.Errorf("error response has cache headers: %v", w.Header())
+	}
+}
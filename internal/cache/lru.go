@@ -0,0 +1,81 @@
+// Package cache implements caching of immutable data, in memory,
+// and by HTTP clients and proxies.
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRU is a bounded cache, evicting the least recently used entry
+// when full. It is safe for concurrent use. A nil *LRU is a valid
+// cache that never stores anything.
+type LRU[K comparable, V any] struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[K]*list.Element
+	// Most recently used first.
+	order list.List
+}
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// NewLRU returns a cache holding at most size entries, or nil, i.e.,
+// no caching, if size is not positive.
+func NewLRU[K comparable, V any](size int) *LRU[K, V] {
+	if size <= 0 {
+		return nil
+	}
+	return &LRU[K, V]{size: size, entries: make(map[K]*list.Element)}
+}
+
+// Get returns the value for key, if present.
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	if c == nil {
+		var zero V
+		return zero, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*entry[K, V]).value, true
+}
+
+// Add adds, or replaces, the value for key.
+func (c *LRU[K, V]) Add(key K, value V) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*entry[K, V]).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		delete(c.entries, oldest.Value.(*entry[K, V]).key)
+		c.order.Remove(oldest)
+	}
+	c.entries[key] = c.order.PushFront(&entry[K, V]{key: key, value: value})
+}
+
+// Len returns the number of entries in the cache.
+func (c *LRU[K, V]) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
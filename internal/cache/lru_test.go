@@ -0,0 +1,43 @@
+package cache
+
+import "testing"
+
+func TestLRU(t *testing.T) {
+	c := NewLRU[int, string](2)
+	c.Add(1, "one")
+	c.Add(2, "two")
+	// Makes 2 the least recently used.
+	if v, ok := c.Get(1); !ok || v != "one" {
+		t.Errorf("unexpected value for 1: %q, %v", v, ok)
+	}
+	c.Add(3, "three")
+	if _, ok := c.Get(2); ok {
+		t.Errorf("least recently used entry not evicted")
+	}
+	for k, want := range map[int]string{1: "one", 3: "three"} {
+		if v, ok := c.Get(k); !ok || v != want {
+			t.Errorf("unexpected value for %d: %q, %v", k, v, ok)
+		}
+	}
+	c.Add(3, "THREE")
+	if v, _ := c.Get(3); v != "THREE" {
+		t.Errorf("value not replaced, got %q", v)
+	}
+	if got, want := c.Len(), 2; got != want {
+		t.Errorf("unexpected length %d, want %d", got, want)
+	}
+}
+
+func TestLRUDisabled(t *testing.T) {
+	c := NewLRU[int, string](0)
+	if c != nil {
+		t.Fatalf("expected nil cache for size 0")
+	}
+	c.Add(1, "one")
+	if _, ok := c.Get(1); ok {
+		t.Errorf("disabled cache returned a value")
+	}
+	if c.Len() != 0 {
+		t.Errorf("disabled cache not empty")
+	}
+}
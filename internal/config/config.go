@@ -8,38 +8,100 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"github.com/pborman/getopt/v2"
+
+	"sigsum.org/log-go/internal/limit"
+	"sigsum.org/log-go/internal/tlsconfig"
 )
 
 // Primary Config
 type Primary struct {
-	PolicyFile          string `toml:"policy-file"`
-	RateLimitFile       string `toml:"rate-limit-file"`
-	AllowTestDomain     bool   `toml:"allow-test-domain"`
-	SecondaryURL        string `toml:"secondary-url"`
-	SecondaryPubkeyFile string `toml:"secondary-pubkey-file"`
-	SthFile             string `toml:"sth-file"`
-	MaxRange            int    `toml:"max-range"`
+	PolicyFile          string        `toml:"policy-file"`
+	RateLimitFile       string        `toml:"rate-limit-file"`
+	AllowTestDomain     bool          `toml:"allow-test-domain"`
+	SecondaryURL        string        `toml:"secondary-url"`
+	SecondaryPubkeyFile string        `toml:"secondary-pubkey-file"`
+	SthFile             string        `toml:"sth-file"`
+	MaxRange            int           `toml:"max-range"`
+	TLSCertFile         string        `toml:"tls-cert-file"`
+	TLSKeyFile          string        `toml:"tls-key-file"`
+	AuditLogFile        string        `toml:"audit-log-file"`
+	AuditLogMaxSize     int64         `toml:"audit-log-max-size"`
+	AuditLogMaxFiles    int           `toml:"audit-log-max-files"`
+	RotateLeaves        int           `toml:"rotate-leaves"`
+	RotateDelay         time.Duration `toml:"rotate-delay"`
+	CosignatureRefresh  time.Duration `toml:"cosignature-refresh-interval"`
+	ProofCacheSize      int           `toml:"proof-cache-size"`
+	LeafCacheSize       int           `toml:"leaf-cache-size"`
+	LeafCacheDir        string        `toml:"leaf-cache-dir"`
+	// Zero means twice the interval, plus timeout.
+	WaitInclusionTimeout time.Duration `toml:"wait-inclusion-timeout"`
 }
 
 // Secondary Config
 type Secondary struct {
-	PrimaryURL string `toml:"primary-url"`
+	PrimaryURL        string `toml:"primary-url"`
+	PrimaryPubkeyFile string `toml:"primary-pubkey-file"`
+	BatchSize         int    `toml:"batch-size"`
+	ParallelFetches   int    `toml:"parallel-fetches"`
+	LongPoll          bool   `toml:"long-poll"`
+	Mirror            bool   `toml:"mirror"`
+}
+
+// Monitor Config
+type Monitor struct {
+	LogURL          string   `toml:"log-url"`
+	LogPubkeyFile   string   `toml:"log-pubkey-file"`
+	PolicyFile      string   `toml:"policy-file"`
+	WatchedKeyFiles []string `toml:"watched-key-files"`
+	WebhookURL      string   `toml:"webhook-url"`
+	StateFile       string   `toml:"state-file"`
+	BatchSize       int      `toml:"batch-size"`
+	// Listen address for metrics and health endpoints; the
+	// monitor doesn't use the internal-endpoint option.
+	MetricsEndpoint string `toml:"metrics-endpoint"`
+}
+
+// Limits for requests to one endpoint, zero means no limit.
+type EndpointLimits struct {
+	MaxConcurrent int           `toml:"max-concurrent"`
+	MaxQueued     int           `toml:"max-queued"`
+	Timeout       time.Duration `toml:"timeout"`
+	MaxBodySize   int64         `toml:"max-body-size"`
 }
 
 type Config struct {
-	Prefix             string        `toml:"url-prefix"`
-	Timeout            time.Duration `toml:"timeout"`
-	Interval           time.Duration `toml:"interval"`
-	LogFile            string        `toml:"log-file"`
-	LogLevel           string        `toml:"log-level"`
-	ExternalEndpoint   string        `toml:"external-endpoint"`
-	InternalEndpoint   string        `toml:"internal-endpoint"`
-	TrillianRpcServer  string        `toml:"trillian-rpc-server"`
-	Backend            string        `toml:"backend"`
-	TrillianTreeIDFile string        `toml:"trillian-tree-id-file"`
-	KeyFile            string        `toml:"key-file"`
-	Primary            `toml:"primary"`
-	Secondary          `toml:"secondary"`
+	Prefix              string        `toml:"url-prefix"`
+	Timeout             time.Duration `toml:"timeout"`
+	Interval            time.Duration `toml:"interval"`
+	LogFile             string        `toml:"log-file"`
+	LogLevel            string        `toml:"log-level"`
+	LogFormat           string        `toml:"log-format"`
+	ExternalEndpoint    string        `toml:"external-endpoint"`
+	InternalEndpoint    string        `toml:"internal-endpoint"`
+	TrillianRpcServer   string        `toml:"trillian-rpc-server"`
+	Backend             string        `toml:"backend"`
+	TrillianTreeIDFile  string        `toml:"trillian-tree-id-file"`
+	KeyFile             string        `toml:"key-file"`
+	InternalTLSCertFile string        `toml:"internal-tls-cert-file"`
+	InternalTLSKeyFile  string        `toml:"internal-tls-key-file"`
+	InternalTLSCAFile   string        `toml:"internal-tls-ca-file"`
+	TrillianTLSCAFile   string        `toml:"trillian-tls-ca-file"`
+	TrillianTLSCertFile string        `toml:"trillian-tls-cert-file"`
+	TrillianTLSKeyFile  string        `toml:"trillian-tls-key-file"`
+	TracingEndpoint     string        `toml:"tracing-endpoint"`
+	// Leaf indexes, kept in memory for the whole tree. The
+	// key-hash index needs about 8 bytes per leaf, the checksum
+	// index about 100 bytes per leaf, since checksums rarely
+	// repeat.
+	KeyHashIndexFile  string `toml:"key-hash-index-file"`
+	ChecksumIndexFile string `toml:"checksum-index-file"`
+	Primary           `toml:"primary"`
+	Secondary         `toml:"secondary"`
+	// Not embedded, since option names overlap with the above.
+	Monitor Monitor `toml:"monitor"`
+
+	// Per-endpoint limits, by endpoint name.
+	EndpointLimits map[string]EndpointLimits `toml:"endpoint-limits"`
 }
 
 func NewConfig() *Config {
@@ -56,6 +118,7 @@ func NewConfig() *Config {
 		Interval:           time.Second * 30,
 		LogFile:            "",
 		LogLevel:           "info",
+		LogFormat:          "text",
 		Primary: Primary{
 			PolicyFile:          "",
 			RateLimitFile:       "",
@@ -64,9 +127,33 @@ func NewConfig() *Config {
 			SecondaryPubkeyFile: "",
 			SthFile:             "/var/lib/sigsum-log/sth",
 			MaxRange:            512,
+			AuditLogFile:        "",
+			AuditLogMaxSize:     100 << 20,
+			AuditLogMaxFiles:    10,
+			CosignatureRefresh:  5 * time.Minute,
+			ProofCacheSize:      10000,
+			LeafCacheSize:       0,
 		},
 		Secondary: Secondary{
-			PrimaryURL: "",
+			PrimaryURL:        "",
+			PrimaryPubkeyFile: "",
+			BatchSize:         512,
+			ParallelFetches:   4,
+			LongPoll:          true,
+		},
+		Monitor: Monitor{
+			BatchSize:       512,
+			MetricsEndpoint: "localhost:6969",
+		},
+		// A config section for an endpoint replaces its
+		// default limits.
+		EndpointLimits: map[string]EndpointLimits{
+			// Each request is a long-poll, holding a
+			// connection for up to wait-inclusion-timeout.
+			"wait-inclusion": {MaxConcurrent: 1000},
+			// Each request may read up to 100 leaves
+			// from the backend.
+			"get-leaves-by-checksum": {MaxConcurrent: 20},
 		},
 	}
 }
@@ -84,6 +171,40 @@ func LoadConfig(f io.Reader) (*Config, error) {
 	return conf, nil
 }
 
+// InternalTLS returns the TLS configuration for internal endpoints.
+func (c *Config) InternalTLS() *tlsconfig.Config {
+	return &tlsconfig.Config{
+		CertFile: c.InternalTLSCertFile,
+		KeyFile:  c.InternalTLSKeyFile,
+		CAFile:   c.InternalTLSCAFile,
+	}
+}
+
+// TrillianTLS returns the TLS configuration for the connection to
+// Trillian.
+func (c *Config) TrillianTLS() *tlsconfig.Config {
+	return &tlsconfig.Config{
+		CertFile: c.TrillianTLSCertFile,
+		KeyFile:  c.TrillianTLSKeyFile,
+		CAFile:   c.TrillianTLSCAFile,
+	}
+}
+
+// Limits returns the limits for the external endpoints, by endpoint
+// name.
+func (c *Config) Limits() map[string]limit.Config {
+	limits := make(map[string]limit.Config)
+	for name, l := range c.EndpointLimits {
+		limits[name] = limit.Config{
+			MaxConcurrent: l.MaxConcurrent,
+			MaxQueued:     l.MaxQueued,
+			Timeout:       l.Timeout,
+			MaxBodySize:   l.MaxBodySize,
+		}
+	}
+	return limits
+}
+
 func OpenConfigFile() (io.Reader, error) {
 	var f io.Reader
 	var err error
@@ -108,10 +229,26 @@ func (c *Config) ServerFlags(set *getopt.Set) {
 	set.FlagLong(&c.TrillianRpcServer, "trillian-rpc-server", 0, "TCP port for Trillian backend server.", "host:port")
 	set.FlagLong(&c.Backend, "backend", 0, "Either \"trillian\" (connect to an external Trillian server) or \"ephemeral\" (use in-memory backend, with NO persistent storage.")
 	set.FlagLong(&c.Prefix, "url-prefix", 0, "Optional URL prefix, preceding endpoint names such as /get-tree-head.", "string")
+	set.FlagLong(&c.TrillianTLSCAFile, "trillian-tls-ca-file", 0, "CA certificate(s) (PEM) for verifying the Trillian server; enables TLS to Trillian.", "file")
+	set.FlagLong(&c.TrillianTLSCertFile, "trillian-tls-cert-file", 0, "Optional client certificate (PEM) for TLS to Trillian; enables TLS to Trillian.", "file")
+	set.FlagLong(&c.TrillianTLSKeyFile, "trillian-tls-key-file", 0, "Private key (PEM) for the Trillian client certificate.", "file")
 	set.FlagLong(&c.TrillianTreeIDFile, "trillian-tree-id-file", 0, "Trillian backend tree identifier.", "file")
-	set.FlagLong(&c.Timeout, "timeout", 0, "Timeout for outgoing requests.")
 	set.FlagLong(&c.KeyFile, "key-file", 0, "Key file (openssh format), either an unencrypted private key, or a public key (accessed via ssh-agent).", "file")
-	set.FlagLong(&c.Interval, "interval", 0, "Interval used to rotate the log's cosigned tree head.")
+	set.FlagLong(&c.InternalTLSCertFile, "internal-tls-cert-file", 0, "Certificate (PEM) for TLS on the internal endpoint, also used as client certificate towards other nodes.", "file")
+	set.FlagLong(&c.InternalTLSKeyFile, "internal-tls-key-file", 0, "Private key (PEM) for the internal TLS certificate.", "file")
+	set.FlagLong(&c.InternalTLSCAFile, "internal-tls-ca-file", 0, "CA certificate(s) (PEM) used to verify other nodes; if set, clients of the internal endpoint must present a valid certificate.", "file")
+	set.FlagLong(&c.KeyHashIndexFile, "key-hash-index-file", 0, "File for an index of leaves by key hash, enabling lookups of leaf indices by key hash.", "file")
+	set.FlagLong(&c.ChecksumIndexFile, "checksum-index-file", 0, "File for an index of leaves by checksum, enabling lookups of leaves by checksum.", "file")
+	c.CommonFlags(set)
+}
+
+// CommonFlags registers the options used by all executables, also
+// the monitor: timeout, interval, logging and tracing.
+func (c *Config) CommonFlags(set *getopt.Set) {
+	set.FlagLong(&c.Timeout, "timeout", 0, "Timeout for outgoing requests.")
+	set.FlagLong(&c.Interval, "interval", 0, "Interval used to rotate the log's cosigned tree head, or for the monitor, to poll the log.")
 	set.FlagLong(&c.LogFile, "log-file", 0, "File to write logs to, or stderr if unset.", "file")
 	set.FlagLong(&c.LogLevel, "log-level", 0, "Log level (Available options: debug, info, warning, error).", "level")
+	set.FlagLong(&c.LogFormat, "log-format", 0, "Log format (Available options: text, json).", "format")
+	set.FlagLong(&c.TracingEndpoint, "tracing-endpoint", 0, "OpenTelemetry collector accepting OTLP over HTTP, e.g., http://localhost:4318; tracing is disabled if unset.", "url")
 }
@@ -4,6 +4,8 @@ import (
 	"os"
 	"strings"
 	"testing"
+
+	"sigsum.org/log-go/internal/limit"
 )
 
 var testConfig = `
@@ -29,6 +31,7 @@ sth-file = "/var/lib/sigsum-log/sth"
 
 [secondary]
 primary-url = "http://localhost:9091"
+primary-pubkey-file = ""
 `
 
 func TestReadConfig(t *testing.T) {
@@ -56,3 +59,34 @@ func TestReadExampleConfigFile(t *testing.T) {
 		t.Fatalf("Failed read configuration: %v", err)
 	}
 }
+
+func TestDefaultLimits(t *testing.T) {
+	conf, err := LoadConfig(strings.NewReader(`
+[endpoint-limits.add-leaf]
+max-concurrent = 10
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := conf.Limits()
+	if got, want := limits["add-leaf"].MaxConcurrent, 10; got != want {
+		t.Errorf("unexpected add-leaf limit, got %d, want %d", got, want)
+	}
+	if got, want := limits["wait-inclusion"].MaxConcurrent, 1000; got != want {
+		t.Errorf("unexpected default wait-inclusion limit, got %d, want %d", got, want)
+	}
+	if got, want := limits["get-leaves-by-checksum"].MaxConcurrent, 20; got != want {
+		t.Errorf("unexpected default get-leaves-by-checksum limit, got %d, want %d", got, want)
+	}
+
+	conf, err = LoadConfig(strings.NewReader(`
+[endpoint-limits.wait-inclusion]
+max-queued = 10
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Limits()["wait-inclusion"], (limit.Config{MaxQueued: 10}); got != want {
+		t.Errorf("unexpected configured wait-inclusion limit, got %v, want %v", got, want)
+	}
+}
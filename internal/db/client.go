@@ -23,4 +23,7 @@ type Client interface {
 	GetConsistencyProof(context.Context, *requests.ConsistencyProof) (types.ConsistencyProof, error)
 	GetInclusionProof(context.Context, *requests.InclusionProof) (types.InclusionProof, error)
 	GetLeaves(context.Context, *requests.Leaves) ([]types.Leaf, error)
+	// Close releases any connection to the backend. The client
+	// must not be used after Close.
+	Close() error
 }
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"git.glasklar.is/sigsum/dependencies/safefile"
+	"sigsum.org/log-go/internal/cache"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Number of leaves per tile, the unit of caching.
+const leafTileSize = 256
+
+// Size of a leaf in binary format, as stored in tile files.
+const leafBinarySize = 2*crypto.HashSize + crypto.SignatureSize
+
+// Sources of leaves, as reported to LeafCacheMetrics.
+const (
+	LeafSourceMemory  = "memory"
+	LeafSourceDisk    = "disk"
+	LeafSourceBackend = "backend"
+)
+
+type LeafCacheMetrics interface {
+	// Called with the number of leaves served from each source.
+	OnLeaves(source string, count int)
+}
+
+// LeafCache is a Client that serves GetLeaves from a cache in front
+// of the backend. Leaves are cached in tiles of 256 leaves, aligned
+// by index, with the least recently used tiles evicted first. The
+// last tile of the tree is typically incomplete, it is extended as
+// the tree grows. Since a leaf never changes once sequenced, cached
+// leaves never need invalidation. Only leaves from the start of a
+// tile are cached. Optionally, complete tiles are also stored on
+// disk, one file per tile, and survive restarts.
+type LeafCache struct {
+	Client
+	tiles   *cache.LRU[uint64, []types.Leaf]
+	dir     string
+	metrics LeafCacheMetrics
+}
+
+// NewLeafCache returns a client caching about size leaves in memory.
+// If dir is non-empty, complete tiles are stored in that directory.
+// Metrics may be nil.
+func NewLeafCache(client Client, size int, dir string, metrics LeafCacheMetrics) *LeafCache {
+	return &LeafCache{
+		Client:  client,
+		tiles:   cache.NewLRU[uint64, []types.Leaf]((size + leafTileSize - 1) / leafTileSize),
+		dir:     dir,
+		metrics: metrics,
+	}
+}
+
+func (c *LeafCache) GetLeaves(ctx context.Context, req *requests.Leaves) ([]types.Leaf, error) {
+	if req.StartIndex >= req.EndIndex {
+		return c.Client.GetLeaves(ctx, req)
+	}
+	leaves := make([]types.Leaf, 0, req.EndIndex-req.StartIndex)
+	for start := req.StartIndex; start < req.EndIndex; {
+		tile := start / leafTileSize
+		tileStart := tile * leafTileSize
+		end := min(req.EndIndex, tileStart+leafTileSize)
+		tileLeaves, err := c.getTile(ctx, tile, start-tileStart, end-tileStart)
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, tileLeaves...)
+		start = end
+	}
+	return leaves, nil
+}
+
+// Returns the leaves at offsets [offset, end) of the given tile, from
+// the cache if possible, otherwise from disk or backend. Only leaves
+// not already cached are fetched from the backend, and they are
+// added to the cache only if they extend the cached leaves, which
+// always start at the beginning of the tile.
+func (c *LeafCache) getTile(ctx context.Context, tile, offset, end uint64) ([]types.Leaf, error) {
+	cached, _ := c.tiles.Get(tile)
+	if uint64(len(cached)) >= end {
+		c.onLeaves(LeafSourceMemory, end-offset)
+		return cached[offset:end], nil
+	}
+	if len(cached) == 0 && c.dir != "" {
+		if leaves, err := c.readTile(tile); err == nil {
+			c.tiles.Add(tile, leaves)
+			c.onLeaves(LeafSourceDisk, end-offset)
+			return leaves[offset:end], nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			log.Warning("reading leaf tile %d failed: %v", tile, err)
+		}
+	}
+	tileStart := tile * leafTileSize
+	from := max(offset, uint64(len(cached)))
+	fetched, err := c.Client.GetLeaves(ctx, &requests.Leaves{
+		StartIndex: tileStart + from,
+		EndIndex:   tileStart + end,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if got, want := len(fetched), int(end-from); got != want {
+		return nil, fmt.Errorf("backend returned %d leaves, expected %d", got, want)
+	}
+	c.onLeaves(LeafSourceMemory, from-offset)
+	c.onLeaves(LeafSourceBackend, end-from)
+	if from > uint64(len(cached)) {
+		// Leaves missing in between, can't be cached.
+		return fetched, nil
+	}
+	// The cached slice is shared with concurrent readers, hence
+	// it is copied rather than appended to in place.
+	leaves := make([]types.Leaf, 0, end)
+	leaves = append(leaves, cached...)
+	leaves = append(leaves, fetched...)
+	c.tiles.Add(tile, leaves)
+
+	if c.dir != "" && len(leaves) == leafTileSize {
+		if err := c.writeTile(tile, leaves); err != nil {
+			log.Warning("writing leaf tile %d failed: %v", tile, err)
+		}
+	}
+	return leaves[offset:end], nil
+}
+
+func (c *LeafCache) onLeaves(source string, count uint64) {
+	if c.metrics != nil && count > 0 {
+		c.metrics.OnLeaves(source, int(count))
+	}
+}
+
+func (c *LeafCache) tileFileName(tile uint64) string {
+	return filepath.Join(c.dir, fmt.Sprintf("tile-%d", tile))
+}
+
+func (c *LeafCache) readTile(tile uint64) ([]types.Leaf, error) {
+	b, err := os.ReadFile(c.tileFileName(tile))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != leafTileSize*leafBinarySize {
+		return nil, fmt.Errorf("invalid tile file size %d", len(b))
+	}
+	leaves := make([]types.Leaf, leafTileSize)
+	for i := range leaves {
+		if err := leaves[i].FromBinary(b[i*leafBinarySize : (i+1)*leafBinarySize]); err != nil {
+			return nil, err
+		}
+	}
+	return leaves, nil
+}
+
+func (c *LeafCache) writeTile(tile uint64, leaves []types.Leaf) error {
+	f, err := safefile.Create(c.tileFileName(tile), 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for i := range leaves {
+		if _, err := f.Write(leaves[i].ToBinary()); err != nil {
+			return err
+		}
+	}
+	return f.Commit()
+}
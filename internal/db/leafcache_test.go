@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Counts leaves requested from the backend.
+type countingClient struct {
+	Client
+	leaves int
+}
+
+func (c *countingClient) GetLeaves(ctx context.Context, req *requests.Leaves) ([]types.Leaf, error) {
+	leaves, err := c.Client.GetLeaves(ctx, req)
+	c.leaves += len(leaves)
+	return leaves, err
+}
+
+type sourceMetrics map[string]int
+
+func (m sourceMetrics) OnLeaves(source string, count int) {
+	m[source] += count
+}
+
+func TestLeafCache(t *testing.T) {
+	leaves := newLeaves(600)
+	memDb := NewMemoryDb()
+	if err := memDb.AddSequencedLeaves(nil, leaves[:300], 0); err != nil {
+		t.Fatalf("AddSequencedLeaves failed: %v", err)
+	}
+	backend := countingClient{Client: memDb}
+	metrics := sourceMetrics{}
+	c := NewLeafCache(&backend, 1000, t.TempDir(), metrics)
+
+	for _, table := range []struct {
+		desc        string
+		start, end  uint64
+		wantBackend int // Total number of leaves from backend.
+	}{
+		// Only requested leaves are fetched, but not cached
+		// unless at the start of the tile.
+		{"not at start of tile", 10, 20, 10},
+		{"first tile", 0, 15, 25},
+		{"cached", 5, 10, 25},
+		{"extend", 10, 30, 40},
+		// 6 leaves not cached, 34 leaves cached.
+		{"across tiles", 250, 290, 80},
+		{"complete first tile", 0, 290, 306},
+		{"tip", 290, 300, 316},
+		{"all cached", 0, 300, 316},
+	} {
+		got, err := c.GetLeaves(context.Background(), &requests.Leaves{StartIndex: table.start, EndIndex: table.end})
+		if err != nil {
+			t.Fatalf("%s: GetLeaves failed: %v", table.desc, err)
+		}
+		if len(got) != int(table.end-table.start) {
+			t.Fatalf("%s: got %d leaves, want %d", table.desc, len(got), table.end-table.start)
+		}
+		for i := range got {
+			if got[i] != leaves[int(table.start)+i] {
+				t.Errorf("%s: wrong leaf at index %d", table.desc, int(table.start)+i)
+			}
+		}
+		if backend.leaves != table.wantBackend {
+			t.Errorf("%s: got %d leaves from backend, want %d", table.desc, backend.leaves, table.wantBackend)
+		}
+	}
+	if got, want := metrics[LeafSourceBackend], 316; got != want {
+		t.Errorf("got %d backend leaves in metrics, want %d", got, want)
+	}
+	if got, want := metrics[LeafSourceMemory], 5+5+30+34+300; got != want {
+		t.Errorf("got %d memory leaves in metrics, want %d", got, want)
+	}
+
+	// The complete first tile is served from disk by a new cache.
+	backend.leaves = 0
+	c = NewLeafCache(&backend, 1000, c.dir, metrics)
+	if _, err := c.GetLeaves(context.Background(), &requests.Leaves{StartIndex: 100, EndIndex: 200}); err != nil {
+		t.Fatalf("GetLeaves failed: %v", err)
+	}
+	if backend.leaves != 0 {
+		t.Errorf("got %d leaves from backend, want none", backend.leaves)
+	}
+	if got, want := metrics[LeafSourceDisk], 100; got != want {
+		t.Errorf("got %d disk leaves in metrics, want %d", got, want)
+	}
+}
+
+func TestLeafCacheBackendError(t *testing.T) {
+	c := NewLeafCache(NewMemoryDb(), 1000, "", nil)
+	if _, err := c.GetLeaves(context.Background(), &requests.Leaves{StartIndex: 0, EndIndex: 1}); err == nil {
+		t.Errorf("GetLeaves beyond end of tree unexpectedly succeeded")
+	}
+}
@@ -112,3 +112,7 @@ func (db *MemoryDb) GetLeaves(_ context.Context, req *requests.Leaves) ([]types.
 	}
 	return list, nil
 }
+
+func (db *MemoryDb) Close() error {
+	return nil
+}
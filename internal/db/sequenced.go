@@ -0,0 +1,113 @@
+package db
+
+import (
+	"sync"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+// Number of leaf hashes kept by sequencedIndex, about 100 bytes each,
+// including overhead.
+const sequencedIndexSize = 1 << 16
+
+// Index of the hashes of the most recently sequenced leaves, up to a
+// tree size that is advanced as tree heads are signed. Answers
+// whether or not a leaf is included in the tree of a given size
+// without any backend requests, so that repeated add-leaf requests
+// for the same leaf, e.g., a submitter polling until it is included,
+// don't need any inclusion proofs. The index covers the leaves with
+// index in the range [start, size); the oldest are evicted first.
+// The zero value is ready to use.
+type sequencedIndex struct {
+	mu    sync.Mutex
+	start uint64
+	size  uint64
+	// Index of each leaf in the range.
+	indexes map[crypto.Hash]uint64
+	// Ring buffer of hashes, in leaf order, for eviction.
+	order []crypto.Hash
+	next  int
+	// Leaves queued as new, with the index size at the time.
+	// Such a leaf is sequenced at an index no smaller than that
+	// size, and hence in the range if it is sequenced at all.
+	queued map[crypto.Hash]uint64
+}
+
+// Returns the tree size covered by the index.
+func (ix *sequencedIndex) treeSize() uint64 {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return ix.size
+}
+
+// Returns the index of the leaf, if it is in the indexed range.
+func (ix *sequencedIndex) leafIndex(leafHash *crypto.Hash) (uint64, bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	index, ok := ix.indexes[*leafHash]
+	return index, ok
+}
+
+// Returns true if the leaf is known not to be included in the tree
+// of the given size. False means that it is either included, or not
+// covered by the index.
+func (ix *sequencedIndex) notIncluded(leafHash *crypto.Hash, treeSize uint64) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if index, ok := ix.indexes[*leafHash]; ok {
+		return index >= treeSize
+	}
+	if treeSize > ix.size {
+		return false
+	}
+	if ix.start == 0 {
+		return true
+	}
+	queuedAt, ok := ix.queued[*leafHash]
+	return ok && queuedAt >= ix.start
+}
+
+// Records that a leaf was queued as new, when the index covered the
+// given tree size. The size must be read before the leaf is queued.
+func (ix *sequencedIndex) addQueued(leafHash *crypto.Hash, treeSize uint64) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.queued == nil || len(ix.queued) >= sequencedIndexSize {
+		// Leaves are removed when indexed, so this happens
+		// only if sequencing is far behind. Forgetting is
+		// safe, it only means more inclusion proofs.
+		ix.queued = make(map[crypto.Hash]uint64)
+	}
+	ix.queued[*leafHash] = treeSize
+}
+
+// Adds the hashes of sequenced leaves, starting at the given index.
+// If there's a gap after the indexed range, the index is reset to
+// start at that index.
+func (ix *sequencedIndex) add(start uint64, leafHashes []crypto.Hash) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.indexes == nil || start > ix.size {
+		ix.start, ix.size = start, start
+		ix.indexes = make(map[crypto.Hash]uint64)
+		ix.order, ix.next = nil, 0
+	}
+	// Skip any leaves already indexed.
+	if start < ix.size {
+		skip := min(ix.size-start, uint64(len(leafHashes)))
+		leafHashes = leafHashes[skip:]
+	}
+	for _, h := range leafHashes {
+		if len(ix.order) < sequencedIndexSize {
+			ix.order = append(ix.order, h)
+		} else {
+			delete(ix.indexes, ix.order[ix.next])
+			ix.order[ix.next] = h
+			ix.next = (ix.next + 1) % sequencedIndexSize
+			ix.start++
+		}
+		ix.indexes[h] = ix.size
+		delete(ix.queued, h)
+		ix.size++
+	}
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+func TestSequencedIndex(t *testing.T) {
+	var ix sequencedIndex
+	h := crypto.Hash{1}
+	if _, ok := ix.leafIndex(&h); ok {
+		t.Errorf("empty index contains leaf")
+	}
+	if !ix.notIncluded(&h, 0) || ix.notIncluded(&h, 1) {
+		t.Errorf("empty index, unexpected result from notIncluded")
+	}
+	ix.add(0, []crypto.Hash{{2}, {3}, {4}, {5}, {6}})
+	ix.add(3, []crypto.Hash{{5}, {6}, {1}})
+	if got, want := ix.treeSize(), uint64(6); got != want {
+		t.Errorf("unexpected size, got %d, want %d", got, want)
+	}
+	if index, ok := ix.leafIndex(&h); !ok || index != 5 {
+		t.Errorf("unexpected leaf index, got %d (%v), want 5", index, ok)
+	}
+	other := crypto.Hash{7}
+	for _, table := range []struct {
+		leafHash *crypto.Hash
+		size     uint64
+		want     bool
+	}{
+		{&h, 5, true},
+		{&h, 6, false},
+		{&other, 6, true},
+		// Not covered.
+		{&other, 7, false},
+	} {
+		if got := ix.notIncluded(table.leafHash, table.size); got != table.want {
+			t.Errorf("leaf %x, size %d: got %v, want %v", *table.leafHash, table.size, got, table.want)
+		}
+	}
+}
+
+func TestSequencedIndexEviction(t *testing.T) {
+	var ix sequencedIndex
+	hash := func(i int) crypto.Hash {
+		return crypto.Hash{byte(i), byte(i >> 8), byte(i >> 16), 1}
+	}
+	queuedHash := crypto.Hash{1}
+	oldQueuedHash := crypto.Hash{2}
+	unknownHash := crypto.Hash{3}
+	// Queued before the oldest leaves are evicted.
+	ix.addQueued(&oldQueuedHash, 0)
+
+	hashes := make([]crypto.Hash, sequencedIndexSize+10)
+	for i := range hashes {
+		hashes[i] = hash(i)
+	}
+	ix.add(0, hashes)
+	ix.addQueued(&queuedHash, ix.treeSize())
+
+	if got, want := len(ix.indexes), sequencedIndexSize; got != want {
+		t.Errorf("unexpected index size, got %d, want %d", got, want)
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok := ix.leafIndex(&hashes[i]); ok {
+			t.Errorf("oldest entry %d not evicted", i)
+		}
+		if ix.notIncluded(&hashes[i], uint64(len(hashes))) {
+			t.Errorf("evicted entry %d reported as not included", i)
+		}
+	}
+	for i := 10; i < len(hashes); i++ {
+		if index, ok := ix.leafIndex(&hashes[i]); !ok || index != uint64(i) {
+			t.Fatalf("entry %d missing, got %d (%v)", i, index, ok)
+		}
+	}
+	size := uint64(len(hashes))
+	if !ix.notIncluded(&queuedHash, size) {
+		t.Errorf("queued leaf not reported as not included")
+	}
+	if ix.notIncluded(&oldQueuedHash, size) {
+		t.Errorf("leaf queued before the indexed range reported as not included")
+	}
+	if ix.notIncluded(&unknownHash, size) {
+		t.Errorf("unknown leaf reported as not included")
+	}
+
+	// Once indexed, a queued leaf is forgotten.
+	ix.add(size, []crypto.Hash{queuedHash})
+	if _, ok := ix.queued[queuedHash]; ok {
+		t.Errorf("indexed leaf still queued")
+	}
+	if ix.notIncluded(&queuedHash, size+1) {
+		t.Errorf("indexed leaf reported as not included")
+	}
+}
+
+func TestSequencedIndexGap(t *testing.T) {
+	var ix sequencedIndex
+	ix.add(0, []crypto.Hash{{1}, {2}})
+	ix.add(10, []crypto.Hash{{3}})
+	if _, ok := ix.leafIndex(&crypto.Hash{1}); ok {
+		t.Errorf("index not reset on gap")
+	}
+	if index, ok := ix.leafIndex(&crypto.Hash{3}); !ok || index != 10 {
+		t.Errorf("unexpected leaf index, got %d (%v), want 10", index, ok)
+	}
+	if ix.notIncluded(&crypto.Hash{4}, 11) {
+		t.Errorf("leaf outside of index reported as not included")
+	}
+}
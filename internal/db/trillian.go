@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"os"
@@ -11,8 +12,13 @@ import (
 	trillianTypes "github.com/google/trillian/types"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
 
+	"sigsum.org/log-go/internal/logging"
+	"sigsum.org/log-go/internal/tracing"
 	"sigsum.org/sigsum-go/pkg/ascii"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/log"
@@ -28,6 +34,17 @@ type TrillianClient struct {
 
 	// logClient is a Trillian gRPC client
 	logClient trillian.TrillianLogClient
+
+	// adminClient is used for checking the tree, and for health
+	// checks.
+	adminClient trillian.TrillianAdminClient
+
+	// conn is the underlying connection, nil in tests.
+	conn *grpc.ClientConn
+
+	// sequenced indexes the most recently sequenced leaves, see
+	// IndexSequenced.
+	sequenced sequencedIndex
 }
 
 type TreeType int
@@ -60,68 +77,175 @@ func (treeType TreeType) checkTrillianTreeType(trillianType trillian.TreeType) e
 	return nil
 }
 
-func DialTrillian(target string, timeout time.Duration, treeType TreeType, treeIdFile string) (*TrillianClient, error) {
+const (
+	// Limits for retrying the initial GetTree request, while
+	// Trillian is unavailable.
+	trillianStartupTimeout = 5 * time.Minute
+	trillianInitialBackoff = time.Second
+	trillianMaxBackoff     = 30 * time.Second
+
+	// Number of leaves fetched per request, when indexing
+	// sequenced leaves.
+	sequencedIndexBatchSize = 256
+)
+
+// DialTrillian creates a client for the Trillian server at target,
+// using TLS if tlsConf is non-nil. The connection is established in
+// the background, and re-established automatically if lost. The tree
+// type is checked at startup, retrying with backoff for a few
+// minutes in case Trillian is not yet available.
+func DialTrillian(target string, timeout time.Duration, treeType TreeType, treeIdFile string, tlsConf *tls.Config) (*TrillianClient, error) {
 	treeId, err := readTreeId(treeIdFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read tree id: %v", err)
 	}
 
-	conn, err := grpc.Dial(target,
-		grpc.WithInsecure(), grpc.WithBlock(),
-		grpc.WithTimeout(timeout))
+	creds := insecure.NewCredentials()
+	if tlsConf != nil {
+		creds = credentials.NewTLS(tlsConf)
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(logging.UnaryClientInterceptor, tracing.UnaryClientInterceptor))
 	if err != nil {
 		return nil, fmt.Errorf("connection to trillian failed: %v", err)
 	}
-	tree, err := trillian.NewTrillianAdminClient(conn).GetTree(
-		context.Background(), &trillian.GetTreeRequest{TreeId: int64(treeId)})
+	client := TrillianClient{
+		treeID:      int64(treeId),
+		logClient:   trillian.NewTrillianLogClient(conn),
+		adminClient: trillian.NewTrillianAdminClient(conn),
+		conn:        conn,
+	}
+	tree, err := client.getTreeWithRetry(timeout, trillianInitialBackoff)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 	if err := treeType.checkTrillianTreeType(tree.TreeType); err != nil {
+		conn.Close()
 		return nil, err
 	}
+	return &client, nil
+}
 
-	return &TrillianClient{
-		treeID:    int64(treeId),
-		logClient: trillian.NewTrillianLogClient(conn),
-	}, nil
+func (c *TrillianClient) getTreeWithRetry(timeout, backoff time.Duration) (*trillian.Tree, error) {
+	deadline := time.Now().Add(trillianStartupTimeout)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		tree, err := c.adminClient.GetTree(ctx, &trillian.GetTreeRequest{TreeId: c.treeID})
+		cancel()
+		if err == nil {
+			return tree, nil
+		}
+		switch status.Code(err) {
+		case codes.Unavailable, codes.DeadlineExceeded:
+		default:
+			return nil, fmt.Errorf("trillian GetTree failed: %v", err)
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("trillian unavailable, giving up: %v", err)
+		}
+		log.Warning("trillian unavailable, retrying in %v: %v", backoff, err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, trillianMaxBackoff)
+	}
+}
+
+// CheckHealth checks that Trillian is reachable, that the tree
+// exists, and that its latest tree head can be read. Used for
+// readiness and watchdog checks.
+func (c *TrillianClient) CheckHealth(ctx context.Context) error {
+	if _, err := c.adminClient.GetTree(ctx, &trillian.GetTreeRequest{TreeId: c.treeID}); err != nil {
+		return fmt.Errorf("trillian health check failed: %v", err)
+	}
+	if _, err := c.GetTreeHead(ctx); err != nil {
+		return fmt.Errorf("trillian health check failed: %v", err)
+	}
+	return nil
+}
+
+// WatchConnection logs changes of the state of the connection to
+// Trillian, until the context is cancelled. Reconnecting is handled
+// by grpc, with backoff; this makes any outages visible in the log.
+func (c *TrillianClient) WatchConnection(ctx context.Context) {
+	state := c.conn.GetState()
+	for c.conn.WaitForStateChange(ctx, state) {
+		newState := c.conn.GetState()
+		switch {
+		case newState == connectivity.TransientFailure:
+			log.Warning("connection to trillian failed, reconnecting")
+		case newState == connectivity.Ready && state != connectivity.Ready:
+			log.Info("connection to trillian ready")
+		default:
+			log.Debug("trillian connection state: %v", newState)
+		}
+		state = newState
+	}
+}
+
+// TreeID returns the ID of the Trillian tree.
+func (c *TrillianClient) TreeID() int64 {
+	return c.treeID
+}
+
+// Close closes the connection to Trillian.
+func (c *TrillianClient) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
 }
 
 // AddLeaf adds a leaf to the tree and returns true if the leaf has
 // been sequenced into the tree of size treeSize.
 func (c *TrillianClient) AddLeaf(ctx context.Context, leaf *types.Leaf, treeSize uint64) (AddLeafStatus, error) {
+	ctx, span := tracing.Start(ctx, "trillian.AddLeaf", "tree_size", treeSize)
+	defer span.End()
+	res, err := c.addLeaf(ctx, leaf, treeSize)
+	span.SetError(err)
+	return res, err
+}
+
+func (c *TrillianClient) addLeaf(ctx context.Context, leaf *types.Leaf, treeSize uint64) (AddLeafStatus, error) {
 	serialized := leaf.ToBinary()
+	leafHash := merkle.HashLeafNode(serialized)
+	if index, ok := c.sequenced.leafIndex(&leafHash); ok {
+		log.Debug("leaf already sequenced: %x, index %d", leafHash, index)
+		return AddLeafStatus{AlreadyExists: true, IsSequenced: index < treeSize}, nil
+	}
+	// Read before queueing, see sequencedIndex.addQueued.
+	indexSize := c.sequenced.treeSize()
 
-	log.Debug("queueing leaf request: %x", merkle.HashLeafNode(serialized))
+	log.Debug("queueing leaf request: %x", leafHash)
 	_, err := c.logClient.QueueLeaf(ctx, &trillian.QueueLeafRequest{
 		LogId: c.treeID,
 		Leaf: &trillian.LogLeaf{
 			LeafValue: serialized,
 		},
 	})
-	var alreadyExists bool
 	switch status.Code(err) {
 	case codes.OK:
-		alreadyExists = false
+		// A new leaf is certainly not sequenced.
+		c.sequenced.addQueued(&leafHash, indexSize)
+		return AddLeafStatus{AlreadyExists: false, IsSequenced: false}, nil
 	case codes.AlreadyExists:
-		alreadyExists = true
 	default:
 		return AddLeafStatus{}, fmt.Errorf("back-end rpc failure: %v", err)
 	}
-	if treeSize == 0 {
+	if treeSize == 0 || c.sequenced.notIncluded(&leafHash, treeSize) {
 		// Certainly not sequenced, and passing treeSize = 0 to Trillian results in an InvalidArgument response.
-		return AddLeafStatus{AlreadyExists: alreadyExists, IsSequenced: false}, nil
+		return AddLeafStatus{AlreadyExists: true, IsSequenced: false}, nil
 	}
-	_, err = c.GetInclusionProof(ctx, &requests.InclusionProof{treeSize, merkle.HashLeafNode(serialized)})
+	// Not covered by the index.
+	_, err = c.GetInclusionProof(ctx, &requests.InclusionProof{treeSize, leafHash})
 	switch err {
 	case nil:
-		return AddLeafStatus{AlreadyExists: alreadyExists, IsSequenced: true}, nil
+		return AddLeafStatus{AlreadyExists: true, IsSequenced: true}, nil
 	case ErrNotIncluded:
-		return AddLeafStatus{AlreadyExists: alreadyExists, IsSequenced: false}, nil
+		return AddLeafStatus{AlreadyExists: true, IsSequenced: false}, nil
 	case errEmptyInclusionProof:
 		if treeSize == 1 {
 			// An empty proof is expected, and means that the leaf is present.
-			return AddLeafStatus{AlreadyExists: alreadyExists, IsSequenced: true}, nil
+			return AddLeafStatus{AlreadyExists: true, IsSequenced: true}, nil
 		}
 		fallthrough
 	default:
@@ -129,6 +253,31 @@ func (c *TrillianClient) AddLeaf(ctx context.Context, leaf *types.Leaf, treeSize
 	}
 }
 
+// IndexSequenced extends the index of sequenced leaves used by
+// AddLeaf, up to the given tree size. It should be called with the
+// size of each new signed tree head. At most the latest 65536 leaves
+// are indexed; the leaves are fetched from the backend.
+func (c *TrillianClient) IndexSequenced(ctx context.Context, treeSize uint64) error {
+	start := c.sequenced.treeSize()
+	if treeSize > sequencedIndexSize {
+		start = max(start, treeSize-sequencedIndexSize)
+	}
+	for start < treeSize {
+		end := min(start+sequencedIndexBatchSize, treeSize)
+		leaves, err := c.GetLeaves(ctx, &requests.Leaves{StartIndex: start, EndIndex: end})
+		if err != nil {
+			return fmt.Errorf("indexing sequenced leaves failed: %w", err)
+		}
+		hashes := make([]crypto.Hash, len(leaves))
+		for i, leaf := range leaves {
+			hashes[i] = merkle.HashLeafNode(leaf.ToBinary())
+		}
+		c.sequenced.add(start, hashes)
+		start = end
+	}
+	return nil
+}
+
 // AddSequencedLeaves adds a set of already sequenced leaves to the tree.
 func (c *TrillianClient) AddSequencedLeaves(ctx context.Context, leaves []types.Leaf, index int64) error {
 	trilLeaves := make([]*trillian.LogLeaf, len(leaves))
@@ -144,6 +293,8 @@ func (c *TrillianClient) AddSequencedLeaves(ctx context.Context, leaves []types.
 		Leaves: trilLeaves,
 	}
 	log.Debug("adding sequenced leaves: count %d", len(trilLeaves))
+	ctx, span := tracing.Start(ctx, "trillian.AddSequencedLeaves", "index", index, "count", len(trilLeaves))
+	defer span.End()
 	var err error
 	for wait := 1; wait < 30; wait *= 2 {
 		var rsp *trillian.AddSequencedLeavesResponse
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/google/trillian"
@@ -41,10 +42,17 @@ func TestAddLeaf(t *testing.T) {
 			queueLeafErr: fmt.Errorf("something went wrong"),
 			wantErr:      true,
 		},
+		{
+			description:   "new",
+			leaf:          leaf,
+			queueLeafErr:  nil,
+			wantErr:       false,
+			wantSequenced: false,
+		},
 		{
 			description:       "unsequenced",
 			leaf:              leaf,
-			queueLeafErr:      nil,
+			queueLeafErr:      status.Error(codes.AlreadyExists, "exists"),
 			inclusionProofErr: status.Error(codes.NotFound, "not found"),
 			wantErr:           false,
 			wantSequenced:     false,
@@ -52,7 +60,7 @@ func TestAddLeaf(t *testing.T) {
 		{
 			description:       "sequenced",
 			leaf:              leaf,
-			queueLeafErr:      nil,
+			queueLeafErr:      status.Error(codes.AlreadyExists, "exists"),
 			inclusionProofErr: nil,
 			wantErr:           false,
 			wantSequenced:     true,
@@ -64,7 +72,8 @@ func TestAddLeaf(t *testing.T) {
 			defer ctrl.Finish()
 			grpc := mocksTrillian.NewMockTrillianLogClient(ctrl)
 			grpc.EXPECT().QueueLeaf(gomock.Any(), gomock.Any()).Return(table.rsp, table.queueLeafErr)
-			if table.queueLeafErr == nil {
+			if status.Code(table.queueLeafErr) == codes.AlreadyExists {
+				// No inclusion proof needed for a new leaf.
 				grpc.EXPECT().GetInclusionProofByHash(gomock.Any(), gomock.Any()).Return(
 					// returns a fake inclusion proof just to pass validation in GetInclusionProof
 					&trillian.GetInclusionProofByHashResponse{
@@ -89,6 +98,53 @@ func TestAddLeaf(t *testing.T) {
 	}
 }
 
+func TestAddLeafSequencedIndex(t *testing.T) {
+	leaf := &types.Leaf{Checksum: crypto.Hash{1}}
+	otherLeaf := &types.Leaf{Checksum: crypto.Hash{2}}
+	newLeaf := &types.Leaf{Checksum: crypto.Hash{3}}
+	leafValue := func(i int) []byte {
+		return (&types.Leaf{Checksum: crypto.Hash{byte(i + 10)}}).ToBinary()
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	grpc := mocksTrillian.NewMockTrillianLogClient(ctrl)
+	grpc.EXPECT().GetLeavesByRange(gomock.Any(), gomock.Any()).Return(
+		&trillian.GetLeavesByRangeResponse{Leaves: []*trillian.LogLeaf{
+			{LeafIndex: 0, LeafValue: leafValue(0)},
+			{LeafIndex: 1, LeafValue: leafValue(1)},
+			{LeafIndex: 2, LeafValue: leaf.ToBinary()},
+			{LeafIndex: 3, LeafValue: leafValue(3)},
+		}}, nil)
+	// Queued only for leaves not in the index, and no inclusion
+	// proofs are needed.
+	grpc.EXPECT().QueueLeaf(gomock.Any(), gomock.Any()).Return(nil, status.Error(codes.AlreadyExists, "exists"))
+	grpc.EXPECT().QueueLeaf(gomock.Any(), gomock.Any()).Return(&trillian.QueueLeafResponse{}, nil)
+	client := TrillianClient{logClient: grpc}
+	if err := client.IndexSequenced(context.Background(), 4); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []struct {
+		leaf     *types.Leaf
+		treeSize uint64
+		want     AddLeafStatus
+	}{
+		{leaf, 2, AddLeafStatus{AlreadyExists: true, IsSequenced: false}},
+		{leaf, 3, AddLeafStatus{AlreadyExists: true, IsSequenced: true}},
+		{leaf, 4, AddLeafStatus{AlreadyExists: true, IsSequenced: true}},
+		{otherLeaf, 4, AddLeafStatus{AlreadyExists: true, IsSequenced: false}},
+		{newLeaf, 4, AddLeafStatus{AlreadyExists: false, IsSequenced: false}},
+	} {
+		status, err := client.AddLeaf(context.Background(), table.leaf, table.treeSize)
+		if err != nil {
+			t.Fatalf("size %d: AddLeaf failed: %v", table.treeSize, err)
+		}
+		if status != table.want {
+			t.Errorf("leaf %x, size %d: got status %v, wanted %v", table.leaf.Checksum, table.treeSize, status, table.want)
+		}
+	}
+}
+
 func TestGetTreeHead(t *testing.T) {
 	// valid root
 	root := &ttypes.LogRootV1{
@@ -509,3 +565,46 @@ func TestGetLeaves(t *testing.T) {
 		}()
 	}
 }
+
+func TestGetTreeWithRetry(t *testing.T) {
+	for _, table := range []struct {
+		description string
+		errs        []error // errors from GetTree, before success
+		wantErr     bool
+	}{
+		{description: "available"},
+		{
+			description: "unavailable once",
+			errs:        []error{status.Error(codes.Unavailable, "connection refused")},
+		},
+		{
+			description: "invalid: missing tree",
+			errs:        []error{status.Error(codes.NotFound, "no such tree")},
+			wantErr:     true,
+		},
+	} {
+		t.Run(table.description, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			admin := mocksTrillian.NewMockTrillianAdminClient(ctrl)
+			var calls []*gomock.Call
+			for _, err := range table.errs {
+				calls = append(calls, admin.EXPECT().GetTree(gomock.Any(), gomock.Any()).Return(nil, err))
+			}
+			if !table.wantErr {
+				calls = append(calls, admin.EXPECT().GetTree(gomock.Any(), gomock.Any()).Return(
+					&trillian.Tree{TreeId: 17, TreeType: trillian.TreeType_LOG}, nil))
+			}
+			gomock.InOrder(calls...)
+			client := TrillianClient{treeID: 17, adminClient: admin}
+
+			tree, err := client.getTreeWithRetry(time.Second, time.Millisecond)
+			if got, want := err != nil, table.wantErr; got != want {
+				t.Fatalf("got error %v but wanted %v: %v", got, want, err)
+			}
+			if err == nil && tree.TreeId != 17 {
+				t.Errorf("unexpected tree id, got %d, wanted 17", tree.TreeId)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Package health implements the liveness and readiness endpoints,
+// /healthz and /readyz, served on the internal endpoint.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+// Timeout for running all readiness checks.
+const checkTimeout = 5 * time.Second
+
+// Check returns an error if some component is not ready.
+type Check struct {
+	Name  string
+	Check func(context.Context) error
+}
+
+// Liveness responds with 200 OK whenever the server is able to
+// handle requests at all.
+func Liveness(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "ok\n")
+}
+
+// Readiness returns a handler running the given checks, in order. It
+// responds with 200 OK if all checks succeed, otherwise with 503
+// Service Unavailable, and a line for each failed check.
+func Readiness(checks ...Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		var failures []string
+		for _, c := range checks {
+			if err := c.Check(ctx); err != nil {
+				log.Debug("readiness check %q failed: %v", c.Name, err)
+				failures = append(failures, fmt.Sprintf("%s: %v", c.Name, err))
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if len(failures) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			for _, f := range failures {
+				fmt.Fprintf(w, "%s\n", f)
+			}
+			return
+		}
+		fmt.Fprintf(w, "ok\n")
+	}
+}
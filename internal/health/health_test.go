@@ -0,0 +1,37 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return fmt.Errorf("mock failure") }
+
+	for _, table := range []struct {
+		description string
+		checks      []Check
+		wantCode    int
+		wantBody    string
+	}{
+		{"no checks", nil, http.StatusOK, "ok\n"},
+		{"all ok", []Check{{"a", ok}, {"b", ok}}, http.StatusOK, "ok\n"},
+		{"one failing", []Check{{"a", ok}, {"b", fail}},
+			http.StatusServiceUnavailable, "b: mock failure\n"},
+		{"all failing", []Check{{"a", fail}, {"b", fail}},
+			http.StatusServiceUnavailable, "a: mock failure\nb: mock failure\n"},
+	} {
+		w := httptest.NewRecorder()
+		Readiness(table.checks...)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if got, want := w.Code, table.wantCode; got != want {
+			t.Errorf("in test %q: unexpected status code, got %d, wanted %d", table.description, got, want)
+		}
+		if got, want := w.Body.String(), table.wantBody; got != want {
+			t.Errorf("in test %q: unexpected body, got %q, wanted %q", table.description, got, want)
+		}
+	}
+}
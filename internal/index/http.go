@@ -0,0 +1,155 @@
+package index
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"sigsum.org/log-go/internal/logging"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	// Maximum number of leaf indices in a lookup response.
+	maxResults = 1000
+	// Maximum number of leaves in a lookup response.
+	maxLeaves = 100
+	// Matching leaves at most this many leaves apart are fetched
+	// in a single backend request, including the leaves between
+	// them.
+	maxGap = 16
+)
+
+// GetLeavesFunc gets leaves from the backend.
+type GetLeavesFunc func(context.Context, *requests.Leaves) ([]types.Leaf, error)
+
+// TreeSizeFunc returns the size of the tree that lookups are limited
+// to, e.g., the size of the published tree head.
+type TreeSizeFunc func(context.Context) (uint64, error)
+
+// Parses the path values "key" (hex) and "start" (decimal).
+func parseLookup(r *http.Request) (crypto.Hash, uint64, error) {
+	var key crypto.Hash
+	b, err := hex.DecodeString(r.PathValue("key"))
+	if err != nil || len(b) != len(key) {
+		return crypto.Hash{}, 0, fmt.Errorf("invalid key %q", r.PathValue("key"))
+	}
+	copy(key[:], b)
+	start, err := strconv.ParseUint(r.PathValue("start"), 10, 64)
+	if err != nil {
+		return crypto.Hash{}, 0, fmt.Errorf("invalid start index: %v", err)
+	}
+	return key, start, nil
+}
+
+// IndicesHandler serves lookups of leaf indices, on a pattern with
+// path values {key}, in hex, and {start}, in decimal. The response
+// lists the indices of matching leaves, at least start and less than
+// the tree size, in increasing order, as lines of the form
+// "leaf_index=<decimal>". There are at most 1000 indices per
+// response; to get more, repeat with start one larger than the last
+// index. If no leaves match, the response is 404.
+func IndicesHandler(ix *Index, treeSize TreeSizeFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, start, err := parseLookup(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		size, err := treeSize(ctx)
+		if err != nil {
+			logging.Warning(ctx, "index lookup failed", "error", err)
+			http.Error(w, "backend failure", http.StatusInternalServerError)
+			return
+		}
+		indices := ix.Lookup(&key, start, size, maxResults)
+		if len(indices) == 0 {
+			http.Error(w, "no matching leaves", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, i := range indices {
+			if _, err := fmt.Fprintf(w, "leaf_index=%d\n", i); err != nil {
+				logging.Warning(ctx, "writing index lookup response failed", "error", err)
+				return
+			}
+		}
+	}
+}
+
+// LeavesHandler is like IndicesHandler, but responds with the
+// matching leaves, at most 100. Each leaf is written in the same
+// format as for get-leaves, followed by a line
+// "leaf_index=<decimal>".
+func LeavesHandler(ix *Index, treeSize TreeSizeFunc, getLeaves GetLeavesFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, start, err := parseLookup(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		size, err := treeSize(ctx)
+		if err != nil {
+			logging.Warning(ctx, "index lookup failed", "error", err)
+			http.Error(w, "backend failure", http.StatusInternalServerError)
+			return
+		}
+		indices := ix.Lookup(&key, start, size, maxLeaves)
+		if len(indices) == 0 {
+			http.Error(w, "no matching leaves", http.StatusNotFound)
+			return
+		}
+		leaves, err := getIndexedLeaves(ctx, getLeaves, indices)
+		if err != nil {
+			logging.Warning(ctx, "index lookup failed", "error", err)
+			http.Error(w, "backend failure", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for j, l := range leaves {
+			if _, err := fmt.Fprintf(w, "leaf=%x %x %x\nleaf_index=%d\n",
+				l.Checksum[:], l.Signature[:], l.KeyHash[:], indices[j]); err != nil {
+				logging.Warning(ctx, "writing index lookup response failed", "error", err)
+				return
+			}
+		}
+	}
+}
+
+// Gets the leaves with the given indices, in increasing order. To
+// reduce the number of backend requests, indices at most maxGap apart
+// are grouped into a single range.
+func getIndexedLeaves(ctx context.Context, getLeaves GetLeavesFunc, indices []uint64) ([]types.Leaf, error) {
+	leaves := make([]types.Leaf, 0, len(indices))
+	for i := 0; i < len(indices); {
+		j := i + 1
+		for j < len(indices) && indices[j]-indices[j-1] <= maxGap {
+			j++
+		}
+		start, end := indices[i], indices[j-1]+1
+		// The backend may return fewer leaves than requested.
+		fetched := make([]types.Leaf, 0, end-start)
+		for next := start; next < end; {
+			l, err := getLeaves(ctx, &requests.Leaves{StartIndex: next, EndIndex: end})
+			if err != nil {
+				return nil, err
+			}
+			if len(l) == 0 || uint64(len(l)) > end-next {
+				return nil, fmt.Errorf("backend returned %d leaves, expected at most %d", len(l), end-next)
+			}
+			fetched = append(fetched, l...)
+			next += uint64(len(l))
+		}
+		for _, index := range indices[i:j] {
+			leaves = append(leaves, fetched[index-start])
+		}
+		i = j
+	}
+	return leaves, nil
+}
@@ -0,0 +1,139 @@
+package index
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Records get-leaves requests to the backend.
+type recordingClient struct {
+	db.Client
+	requests []requests.Leaves
+}
+
+func (c *recordingClient) GetLeaves(ctx context.Context, req *requests.Leaves) ([]types.Leaf, error) {
+	c.requests = append(c.requests, *req)
+	return c.Client.GetLeaves(ctx, req)
+}
+
+func TestIndicesHandler(t *testing.T) {
+	ix, err := Open(filepath.Join(t.TempDir(), "index"), KeyHash, 1)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer ix.Close()
+	if err := ix.Add(0, newLeaves(10, 3)); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /lookup/{key}/{start}", IndicesHandler(ix,
+		func(context.Context) (uint64, error) { return 9, nil }))
+	key := fmt.Sprintf("01%062x", 0)
+	key0 := fmt.Sprintf("%064x", 0)
+
+	for _, table := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/lookup/" + key + "/0", http.StatusOK, "leaf_index=1\nleaf_index=4\nleaf_index=7\n"},
+		{"/lookup/" + key + "/5", http.StatusOK, "leaf_index=7\n"},
+		{"/lookup/" + key0 + "/7", http.StatusNotFound, ""}, // Leaf 9 is beyond the tree.
+		{"/lookup/" + key[:10] + "/0", http.StatusBadRequest, ""},
+		{"/lookup/" + key + "/x", http.StatusBadRequest, ""},
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, table.path, nil))
+		if w.Code != table.code {
+			t.Errorf("%s: got status %d, want %d", table.path, w.Code, table.code)
+		} else if table.code == http.StatusOK && w.Body.String() != table.body {
+			t.Errorf("%s: got body %q, want %q", table.path, w.Body.String(), table.body)
+		}
+	}
+}
+
+func TestLeavesHandler(t *testing.T) {
+	leaves := newLeaves(10, 3)
+	// Two leaves with the same checksum, but different signatures.
+	leaves[5].Checksum = leaves[2].Checksum
+	leaves[5].Signature[0] = 1
+	memDb := db.NewMemoryDb()
+	if err := memDb.AddSequencedLeaves(nil, leaves, 0); err != nil {
+		t.Fatalf("AddSequencedLeaves failed: %v", err)
+	}
+	s, err := OpenSet("", filepath.Join(t.TempDir(), "index"), 1)
+	if err != nil {
+		t.Fatalf("OpenSet failed: %v", err)
+	}
+	defer s.Close()
+	if err := s.Checksum.Add(0, leaves); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	backend := recordingClient{Client: memDb}
+	mux := http.NewServeMux()
+	s.Register(mux, "/", func(context.Context) (uint64, error) { return 10, nil }, &backend)
+
+	checksum := hex.EncodeToString(leaves[2].Checksum[:])
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/get-leaves-by-checksum/"+checksum+"/0", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	want := ""
+	for _, i := range []int{2, 5} {
+		want += fmt.Sprintf("leaf=%x %x %x\nleaf_index=%d\n",
+			leaves[i].Checksum[:], leaves[i].Signature[:], leaves[i].KeyHash[:], i)
+	}
+	if got := w.Body.String(); got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+	// Nearby leaves are fetched with a single request.
+	if got, want := backend.requests, []requests.Leaves{{StartIndex: 2, EndIndex: 6}}; !slices.Equal(got, want) {
+		t.Errorf("unexpected backend requests, got %v, want %v", got, want)
+	}
+
+	// Key hash index not enabled.
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/get-indices-by-key-hash/"+checksum+"/0", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("disabled index: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestGetIndexedLeaves(t *testing.T) {
+	leaves := newLeaves(50, 3)
+	var requested []requests.Leaves
+	// Backend returning at most 5 leaves per request.
+	getLeaves := func(_ context.Context, req *requests.Leaves) ([]types.Leaf, error) {
+		requested = append(requested, *req)
+		return leaves[req.StartIndex:min(req.EndIndex, req.StartIndex+5)], nil
+	}
+	indices := []uint64{0, 1, 10, 30, 47}
+	got, err := getIndexedLeaves(context.Background(), getLeaves, indices)
+	if err != nil {
+		t.Fatalf("getIndexedLeaves failed: %v", err)
+	}
+	for i, index := range indices {
+		if got[i] != leaves[index] {
+			t.Errorf("wrong leaf for index %d", index)
+		}
+	}
+	want := []requests.Leaves{
+		{StartIndex: 0, EndIndex: 11}, {StartIndex: 5, EndIndex: 11}, {StartIndex: 10, EndIndex: 11},
+		{StartIndex: 30, EndIndex: 31},
+		{StartIndex: 47, EndIndex: 48},
+	}
+	if !slices.Equal(requested, want) {
+		t.Errorf("unexpected backend requests, got %v, want %v", requested, want)
+	}
+}
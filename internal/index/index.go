@@ -0,0 +1,198 @@
+// Package index implements optional secondary indexes of the log's
+// leaves, e.g., by key hash, maintained as leaves are sequenced.
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// KeyFunc extracts the key a leaf is indexed by.
+type KeyFunc func(*types.Leaf) crypto.Hash
+
+// KeyHash indexes leaves by the hash of the submitter's public key.
+func KeyHash(leaf *types.Leaf) crypto.Hash {
+	return leaf.KeyHash
+}
+
+// Checksum indexes leaves by checksum, i.e., the hash of the signed
+// message.
+func Checksum(leaf *types.Leaf) crypto.Hash {
+	return leaf.Checksum
+}
+
+// The index file starts with a header of the same size as a record:
+// a magic string, followed by the backend's tree ID, so that an
+// index file isn't used with the wrong tree.
+const indexMagic = "log-go leaf index, v1\n"
+
+func indexHeader(treeID int64) []byte {
+	header := make([]byte, crypto.HashSize)
+	copy(header, indexMagic)
+	binary.BigEndian.PutUint64(header[crypto.HashSize-8:], uint64(treeID))
+	return header
+}
+
+// Index maps keys to the indices of all leaves with that key. It is
+// kept in memory, and backed by a file with the key of each leaf, in
+// order, so that it needn't be rebuilt from the backend at startup.
+// Memory usage grows with the tree, by roughly 8 bytes per leaf plus
+// 100 bytes per distinct key. It is safe for concurrent use.
+type Index struct {
+	key  KeyFunc
+	file *os.File
+
+	mu sync.RWMutex
+	// Number of leaves indexed.
+	size uint64
+	// Leaf indices for each key, in increasing order.
+	indices map[crypto.Hash][]uint64
+}
+
+// Open loads the index for the tree with the given ID from the given
+// file, creating it if it doesn't exist. If the file belongs to a
+// different tree, it is emptied, and the index is rebuilt.
+func Open(name string, key KeyFunc, treeID int64) (*Index, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	ix, err := load(f, key, treeID)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("loading index file %q failed: %v", name, err)
+	}
+	return ix, nil
+}
+
+func load(f *os.File, key KeyFunc, treeID int64) (*Index, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	header := indexHeader(treeID)
+	if len(data) < len(header) || !bytes.Equal(data[:len(header)], header) {
+		if len(data) > 0 {
+			log.Warning("index file %q is not for tree %d, rebuilding", f.Name(), treeID)
+		}
+		if err := f.Truncate(0); err != nil {
+			return nil, err
+		}
+		if _, err := f.WriteAt(header, 0); err != nil {
+			return nil, err
+		}
+		data = header
+	}
+	// A partial record may be left by a crash, it is discarded.
+	if len(data)%crypto.HashSize != 0 {
+		log.Warning("discarding partial record at end of index file %q", f.Name())
+		data = data[:len(data)-len(data)%crypto.HashSize]
+		if err := f.Truncate(int64(len(data))); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := f.Seek(int64(len(data)), io.SeekStart); err != nil {
+		return nil, err
+	}
+	ix := Index{key: key, file: f, indices: make(map[crypto.Hash][]uint64)}
+	for data = data[len(header):]; len(data) > 0; data = data[crypto.HashSize:] {
+		var k crypto.Hash
+		copy(k[:], data)
+		ix.indices[k] = append(ix.indices[k], ix.size)
+		ix.size++
+	}
+	return &ix, nil
+}
+
+// Offset in the file of the record for the given leaf index.
+func recordOffset(index uint64) int64 {
+	// The header occupies the first record.
+	return int64(index+1) * crypto.HashSize
+}
+
+// Empties the index, so that it is rebuilt from the start.
+func (ix *Index) reset() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if err := ix.file.Truncate(recordOffset(0)); err != nil {
+		return err
+	}
+	if _, err := ix.file.Seek(recordOffset(0), io.SeekStart); err != nil {
+		return err
+	}
+	ix.size = 0
+	ix.indices = make(map[crypto.Hash][]uint64)
+	return nil
+}
+
+// Size returns the number of leaves indexed.
+func (ix *Index) Size() uint64 {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.size
+}
+
+// Add indexes the given leaves, where the first one is at index
+// start. Leaves already indexed are skipped, and it is an error if
+// start is larger than the current size.
+func (ix *Index) Add(start uint64, leaves []types.Leaf) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if start > ix.size {
+		return fmt.Errorf("leaves at index %d, after end of index %d", start, ix.size)
+	}
+	if skip := ix.size - start; skip < uint64(len(leaves)) {
+		leaves = leaves[skip:]
+	} else {
+		return nil
+	}
+	keys := make([]crypto.Hash, len(leaves))
+	data := make([]byte, 0, len(leaves)*crypto.HashSize)
+	for i := range leaves {
+		keys[i] = ix.key(&leaves[i])
+		data = append(data, keys[i][:]...)
+	}
+	if _, err := ix.file.Write(data); err != nil {
+		// Drop any partially written records.
+		offset := recordOffset(ix.size)
+		if err := ix.file.Truncate(offset); err != nil {
+			log.Error("truncating index file %q failed: %v", ix.file.Name(), err)
+		} else if _, err := ix.file.Seek(offset, io.SeekStart); err != nil {
+			log.Error("seeking in index file %q failed: %v", ix.file.Name(), err)
+		}
+		return err
+	}
+	for _, k := range keys {
+		ix.indices[k] = append(ix.indices[k], ix.size)
+		ix.size++
+	}
+	return nil
+}
+
+// Lookup returns the indices of leaves with the given key, starting
+// at index start and less than end, at most max of them.
+func (ix *Index) Lookup(key *crypto.Hash, start, end uint64, max int) []uint64 {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	indices := ix.indices[*key]
+	i := sort.Search(len(indices), func(i int) bool { return indices[i] >= start })
+	var res []uint64
+	for ; i < len(indices) && indices[i] < end && len(res) < max; i++ {
+		res = append(res, indices[i])
+	}
+	return res
+}
+
+// Close closes the index file.
+func (ix *Index) Close() error {
+	return ix.file.Close()
+}
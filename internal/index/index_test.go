@@ -0,0 +1,176 @@
+package index
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Returns n leaves, where leaf i has key hash {i % keys}.
+func newLeaves(n, keys int) []types.Leaf {
+	leaves := make([]types.Leaf, n)
+	for i := range leaves {
+		leaves[i].Checksum = crypto.Hash{byte(i), byte(i >> 8)}
+		leaves[i].KeyHash = crypto.Hash{byte(i % keys)}
+	}
+	return leaves
+}
+
+func TestIndex(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "index")
+	ix, err := Open(name, KeyHash, 1)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	leaves := newLeaves(10, 3)
+	if err := ix.Add(0, leaves[:4]); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := ix.Add(6, leaves[6:]); err == nil {
+		t.Errorf("Add with gap unexpectedly succeeded")
+	}
+	// Overlapping with already indexed leaves.
+	if err := ix.Add(2, leaves[2:8]); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if got := ix.Size(); got != 8 {
+		t.Errorf("got size %d, want 8", got)
+	}
+
+	key := crypto.Hash{1}
+	for _, table := range []struct {
+		start, end uint64
+		max        int
+		want       []uint64
+	}{
+		{0, 8, 10, []uint64{1, 4, 7}},
+		{2, 8, 10, []uint64{4, 7}},
+		{0, 7, 10, []uint64{1, 4}},
+		{0, 8, 2, []uint64{1, 4}},
+		{8, 10, 10, nil},
+	} {
+		if got := ix.Lookup(&key, table.start, table.end, table.max); !reflect.DeepEqual(got, table.want) {
+			t.Errorf("Lookup(%d, %d, %d): got %v, want %v", table.start, table.end, table.max, got, table.want)
+		}
+	}
+	if err := ix.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Simulate a crash in the middle of writing a record.
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{1, 2, 3})
+	f.Close()
+
+	ix, err = Open(name, KeyHash, 1)
+	if err != nil {
+		t.Fatalf("Open of existing index failed: %v", err)
+	}
+	defer ix.Close()
+	if got := ix.Size(); got != 8 {
+		t.Errorf("got size %d after reopen, want 8", got)
+	}
+	if err := ix.Add(8, leaves[8:]); err != nil {
+		t.Fatalf("Add after reopen failed: %v", err)
+	}
+	if got, want := ix.Lookup(&key, 0, 10, 10), []uint64{1, 4, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Lookup after reopen: got %v, want %v", got, want)
+	}
+	if got, want := ix.Lookup(&crypto.Hash{2}, 0, 10, 10), []uint64{2, 5, 8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Lookup after reopen: got %v, want %v", got, want)
+	}
+}
+
+func TestIndexer(t *testing.T) {
+	leaves := newLeaves(600, 2)
+	memDb := db.NewMemoryDb()
+	if err := memDb.AddSequencedLeaves(nil, leaves, 0); err != nil {
+		t.Fatalf("AddSequencedLeaves failed: %v", err)
+	}
+	ix, err := Open(filepath.Join(t.TempDir(), "index"), KeyHash, 1)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer ix.Close()
+	indexer := Indexer{DbClient: memDb, Indexes: []*Index{ix}}
+	if err := indexer.update(context.Background()); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if got := ix.Size(); got != 600 {
+		t.Errorf("got size %d, want 600", got)
+	}
+	if got := len(ix.Lookup(&crypto.Hash{1}, 0, 600, 1000)); got != 300 {
+		t.Errorf("got %d matching leaves, want 300", got)
+	}
+	// An index larger than the backend tree is rebuilt.
+	otherDb := db.NewMemoryDb()
+	if err := otherDb.AddSequencedLeaves(nil, newLeaves(10, 5), 0); err != nil {
+		t.Fatalf("AddSequencedLeaves failed: %v", err)
+	}
+	indexer.DbClient = otherDb
+	if err := indexer.update(context.Background()); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if got := ix.Size(); got != 10 {
+		t.Errorf("got size %d after rebuild, want 10", got)
+	}
+	if got, want := ix.Lookup(&crypto.Hash{1}, 0, 10, 1000), []uint64{1, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got matching leaves %v after rebuild, want %v", got, want)
+	}
+}
+
+func TestIndexOtherTree(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "index")
+	ix, err := Open(name, KeyHash, 1)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := ix.Add(0, newLeaves(4, 2)); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	ix.Close()
+
+	// Opening for another tree, the index is rebuilt.
+	ix, err = Open(name, KeyHash, 2)
+	if err != nil {
+		t.Fatalf("Open for other tree failed: %v", err)
+	}
+	if got := ix.Size(); got != 0 {
+		t.Errorf("got size %d for other tree, want 0", got)
+	}
+	if err := ix.Add(0, newLeaves(2, 2)); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	ix.Close()
+
+	ix, err = Open(name, KeyHash, 2)
+	if err != nil {
+		t.Fatalf("Open of existing index failed: %v", err)
+	}
+	defer ix.Close()
+	if got := ix.Size(); got != 2 {
+		t.Errorf("got size %d after reopen, want 2", got)
+	}
+
+	// A file without a valid header is rebuilt too.
+	if err := os.WriteFile(name, make([]byte, 3*crypto.HashSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ix2, err := Open(name, KeyHash, 2)
+	if err != nil {
+		t.Fatalf("Open of invalid file failed: %v", err)
+	}
+	defer ix2.Close()
+	if got := ix2.Size(); got != 0 {
+		t.Errorf("got size %d for invalid file, want 0", got)
+	}
+}
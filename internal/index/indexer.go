@@ -0,0 +1,81 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/requests"
+)
+
+const (
+	// How often to poll the backend for new leaves.
+	pollInterval = time.Second
+	// Number of leaves to fetch from the backend per request.
+	batchSize = 256
+)
+
+// Indexer keeps indexes up to date with the leaves sequenced by the
+// backend.
+type Indexer struct {
+	DbClient db.Client
+	Indexes  []*Index
+}
+
+// Run indexes new leaves until the context is cancelled.
+func (ixr *Indexer) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := ixr.update(ctx); err != nil {
+			log.Warning("updating index failed: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Indexes all leaves in the current backend tree.
+func (ixr *Indexer) update(ctx context.Context) error {
+	if len(ixr.Indexes) == 0 {
+		return nil
+	}
+	th, err := ixr.DbClient.GetTreeHead(ctx)
+	if err != nil {
+		return err
+	}
+	start := th.Size
+	for _, ix := range ixr.Indexes {
+		if size := ix.Size(); size > th.Size {
+			// Can't be the same tree, since the backend
+			// tree never shrinks.
+			log.Warning("index has %d leaves, more than the backend tree, size %d, rebuilding", size, th.Size)
+			if err := ix.reset(); err != nil {
+				return err
+			}
+		}
+		start = min(start, ix.Size())
+	}
+	for start < th.Size {
+		end := min(start+batchSize, th.Size)
+		leaves, err := ixr.DbClient.GetLeaves(ctx, &requests.Leaves{StartIndex: start, EndIndex: end})
+		if err != nil {
+			return err
+		}
+		if len(leaves) == 0 {
+			return fmt.Errorf("backend get leaves returned an empty list")
+		}
+		for _, ix := range ixr.Indexes {
+			if err := ix.Add(start, leaves); err != nil {
+				return err
+			}
+		}
+		start += uint64(len(leaves))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"sigsum.org/log-go/internal/db"
+)
+
+// Set is the indexes enabled on a node, each nil if disabled.
+type Set struct {
+	KeyHash  *Index
+	Checksum *Index
+}
+
+// OpenSet opens the indexes with non-empty file names, for the tree
+// with the given ID.
+func OpenSet(keyHashFile, checksumFile string, treeID int64) (*Set, error) {
+	var s Set
+	var err error
+	if keyHashFile != "" {
+		if s.KeyHash, err = Open(keyHashFile, KeyHash, treeID); err != nil {
+			return nil, err
+		}
+	}
+	if checksumFile != "" {
+		if s.Checksum, err = Open(checksumFile, Checksum, treeID); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+func (s *Set) indexes() []*Index {
+	var indexes []*Index
+	for _, ix := range []*Index{s.KeyHash, s.Checksum} {
+		if ix != nil {
+			indexes = append(indexes, ix)
+		}
+	}
+	return indexes
+}
+
+// Run keeps the indexes up to date, until the context is cancelled.
+func (s *Set) Run(ctx context.Context, client db.Client) {
+	indexes := s.indexes()
+	if len(indexes) == 0 {
+		return
+	}
+	indexer := Indexer{DbClient: client, Indexes: indexes}
+	indexer.Run(ctx)
+}
+
+// Register adds lookup endpoints for the enabled indexes, with the
+// given pattern prefix.
+func (s *Set) Register(mux *http.ServeMux, pattern string, treeSize TreeSizeFunc, client db.Client) {
+	if s.KeyHash != nil {
+		mux.HandleFunc("GET "+pattern+"get-indices-by-key-hash/{key}/{start}",
+			IndicesHandler(s.KeyHash, treeSize))
+	}
+	if s.Checksum != nil {
+		mux.HandleFunc("GET "+pattern+"get-leaves-by-checksum/{key}/{start}",
+			LeavesHandler(s.Checksum, treeSize, client.GetLeaves))
+	}
+}
+
+// Close closes all index files.
+func (s *Set) Close() error {
+	var errs []error
+	for _, ix := range s.indexes() {
+		errs = append(errs, ix.Close())
+	}
+	return errors.Join(errs...)
+}
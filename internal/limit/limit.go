@@ -0,0 +1,122 @@
+// Package limit implements per-endpoint limits on incoming requests,
+// to keep the backend from being overwhelmed.
+package limit
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"sigsum.org/log-go/internal/logging"
+)
+
+// Config specifies limits for requests to one endpoint. Zero values
+// mean no limit.
+type Config struct {
+	// Maximum number of requests handled concurrently.
+	MaxConcurrent int
+	// Maximum number of requests waiting for their turn, when
+	// MaxConcurrent requests are already being handled. Further
+	// requests are rejected with 503 Service Unavailable.
+	MaxQueued int
+	// Time limit for handling a request, including any time
+	// spent waiting in the queue.
+	Timeout time.Duration
+	// Maximum size of the request body, in bytes.
+	MaxBodySize int64
+}
+
+type endpoint struct {
+	config Config
+	// Buffered channel of size MaxConcurrent, or nil if
+	// concurrency is unlimited.
+	slots  chan struct{}
+	queued atomic.Int64
+}
+
+// Waits for a slot, returns false if the queue is full or if ctx is
+// done before a slot is available.
+func (e *endpoint) acquire(ctx context.Context) bool {
+	if e.slots == nil {
+		return true
+	}
+	select {
+	case e.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if e.queued.Add(1) > int64(e.config.MaxQueued) {
+		e.queued.Add(-1)
+		return false
+	}
+	defer e.queued.Add(-1)
+	select {
+	case e.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (e *endpoint) release() {
+	if e.slots != nil {
+		<-e.slots
+	}
+}
+
+// Middleware applies limits to requests, by endpoint name, i.e.,
+// the first path element after the prefix, e.g., "add-leaf".
+// Requests to endpoints not in limits are passed on unchanged.
+func Middleware(prefix string, limits map[string]Config, next http.Handler) http.Handler {
+	if len(limits) == 0 {
+		return next
+	}
+	endpoints := make(map[string]*endpoint)
+	for name, config := range limits {
+		e := endpoint{config: config}
+		if config.MaxConcurrent > 0 {
+			e.slots = make(chan struct{}, config.MaxConcurrent)
+		}
+		endpoints[name] = &e
+	}
+	if prefix != "" {
+		prefix = "/" + prefix
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := endpointName(prefix, r.URL.Path)
+		e := endpoints[name]
+		if !ok || e == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if e.config.Timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), e.config.Timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		if !e.acquire(r.Context()) {
+			logging.Debug(r.Context(), "request rejected, endpoint saturated", "endpoint", name)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "endpoint saturated, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		defer e.release()
+		if e.config.MaxBodySize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, e.config.MaxBodySize)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Extracts the endpoint name from a path on the form
+// <prefix>/<name>[/...].
+func endpointName(prefix, path string) (string, bool) {
+	path, ok := strings.CutPrefix(path, prefix+"/")
+	if !ok {
+		return "", false
+	}
+	name, _, _ := strings.Cut(path, "/")
+	return name, true
+}
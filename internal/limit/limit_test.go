@@ -0,0 +1,151 @@
+package limit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEndpointName(t *testing.T) {
+	for _, table := range []struct {
+		prefix, path string
+		want         string
+		wantOk       bool
+	}{
+		{"", "/add-leaf", "add-leaf", true},
+		{"", "/get-leaves/0/10", "get-leaves", true},
+		{"/foo", "/foo/get-tree-head", "get-tree-head", true},
+		{"/foo", "/get-tree-head", "", false},
+		{"/foo", "/foobar/get-tree-head", "", false},
+	} {
+		name, ok := endpointName(table.prefix, table.path)
+		if name != table.want || ok != table.wantOk {
+			t.Errorf("prefix %q, path %q: got (%q, %v), want (%q, %v)",
+				table.prefix, table.path, name, ok, table.want, table.wantOk)
+		}
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	e := endpoint{config: Config{MaxConcurrent: 1, MaxQueued: 1}, slots: make(chan struct{}, 1)}
+	if !e.acquire(context.Background()) {
+		t.Fatalf("acquire of free slot failed")
+	}
+	done := make(chan bool)
+	go func() { done <- e.acquire(context.Background()) }()
+	for e.queued.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if e.acquire(context.Background()) {
+		t.Errorf("acquire with full queue succeeded")
+	}
+	e.release()
+	if !<-done {
+		t.Errorf("acquire of queued request failed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- e.acquire(ctx) }()
+	for e.queued.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if <-done {
+		t.Errorf("acquire with cancelled context succeeded")
+	}
+}
+
+// Returns a handler blocking until unblock is closed, and signaling
+// on started when a request is being handled.
+func blockingHandler(started chan<- struct{}, unblock <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	handler := Middleware("log", map[string]Config{
+		"add-leaf": Config{MaxConcurrent: 1},
+	}, blockingHandler(started, unblock))
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/log/add-leaf", nil))
+		done <- w.Code
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/log/add-leaf", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("saturated endpoint: got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("got Retry-After %q, want \"1\"", got)
+	}
+	close(unblock)
+
+	// Other endpoints are not limited.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/log/get-tree-head", nil))
+	<-started
+	if w.Code != http.StatusOK {
+		t.Errorf("unlimited endpoint: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("first request: got status %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	defer close(unblock)
+	handler := Middleware("", map[string]Config{
+		"get-leaves": Config{MaxConcurrent: 1, MaxQueued: 1, Timeout: 10 * time.Millisecond},
+	}, blockingHandler(started, unblock))
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/get-leaves/0/1", nil))
+	<-started
+
+	// Queued, and rejected when the timeout expires.
+	w := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/get-leaves/0/1", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Errorf("rejected after %v, before timeout", d)
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	handler := Middleware("", map[string]Config{
+		"add-leaf": Config{MaxBodySize: 10},
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	}))
+	for _, table := range []struct {
+		body string
+		want int
+	}{
+		{"short", http.StatusOK},
+		{"too long request body", http.StatusRequestEntityTooLarge},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/add-leaf", strings.NewReader(table.body)))
+		if w.Code != table.want {
+			t.Errorf("body %q: got status %d, want %d", table.body, w.Code, table.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Package logging adds structured logging, with request correlation,
+// on top of sigsum-go's log package. With the default text format,
+// messages are logged via sigsum-go's log package, with attributes
+// formatted as key=value pairs. With the JSON format, every message,
+// including those logged directly via sigsum-go's log package, is
+// written as a JSON object on a line of its own.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	stdlog "log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+// Non-nil when JSON output is enabled.
+var structured *slog.Logger
+
+// Setup configures log output according to format, "text" (default)
+// or "json". If file is non-empty, output is appended to that file,
+// otherwise written to stderr.
+func Setup(format, file, level string) error {
+	switch format {
+	case "", "text":
+		if file != "" {
+			return log.SetLogFile(file)
+		}
+		return nil
+	case "json":
+		w := os.Stderr
+		if file != "" {
+			f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			w = f
+		}
+		slogLevel, err := parseLevel(level)
+		if err != nil {
+			return err
+		}
+		structured = slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slogLevel}))
+		// sigsum-go's log package writes via the standard
+		// library logger; parse and reformat those messages.
+		stdlog.SetFlags(0)
+		stdlog.SetOutput(stdlibWriter{structured})
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q, must be \"text\" or \"json\"", format)
+	}
+}
+
+func parseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", level)
+	}
+}
+
+// Debug logs a message with attributes, given as alternating keys and
+// values, and the request id from the context, if any.
+func Debug(ctx context.Context, msg string, args ...any) {
+	if structured != nil {
+		structured.Log(ctx, slog.LevelDebug, msg, withRequestID(ctx, args)...)
+	} else {
+		log.Debug("%s", formatText(ctx, msg, args))
+	}
+}
+
+func Info(ctx context.Context, msg string, args ...any) {
+	if structured != nil {
+		structured.Log(ctx, slog.LevelInfo, msg, withRequestID(ctx, args)...)
+	} else {
+		log.Info("%s", formatText(ctx, msg, args))
+	}
+}
+
+func Warning(ctx context.Context, msg string, args ...any) {
+	if structured != nil {
+		structured.Log(ctx, slog.LevelWarn, msg, withRequestID(ctx, args)...)
+	} else {
+		log.Warning("%s", formatText(ctx, msg, args))
+	}
+}
+
+func Error(ctx context.Context, msg string, args ...any) {
+	if structured != nil {
+		structured.Log(ctx, slog.LevelError, msg, withRequestID(ctx, args)...)
+	} else {
+		log.Error("%s", formatText(ctx, msg, args))
+	}
+}
+
+func withRequestID(ctx context.Context, args []any) []any {
+	if id := RequestID(ctx); id != "" {
+		return append([]any{"request_id", id}, args...)
+	}
+	return args
+}
+
+// Formats message and attributes as "msg key=value ...".
+func formatText(ctx context.Context, msg string, args []any) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	args = withRequestID(ctx, args)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+	return b.String()
+}
+
+// Adapts output from the standard library logger, on the form
+// "[LEVEL] message", to structured logging.
+type stdlibWriter struct {
+	logger *slog.Logger
+}
+
+func (w stdlibWriter) Write(p []byte) (int, error) {
+	msg := string(bytes.TrimRight(p, "\n"))
+	level := slog.LevelInfo
+	for _, l := range []struct {
+		prefix string
+		level  slog.Level
+	}{
+		{"[DEBUG] ", slog.LevelDebug},
+		{"[INFO] ", slog.LevelInfo},
+		{"[WARNING] ", slog.LevelWarn},
+		{"[ERROR] ", slog.LevelError},
+		{"[FATAL] ", slog.LevelError + 4},
+	} {
+		if strings.HasPrefix(msg, l.prefix) {
+			msg, level = msg[len(l.prefix):], l.level
+			break
+		}
+	}
+	w.logger.Log(context.Background(), level, msg)
+	return len(p), nil
+}
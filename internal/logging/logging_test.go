@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Enables JSON output to a buffer, for the duration of the test.
+func mustCaptureJSON(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := structured
+	structured = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	t.Cleanup(func() { structured = prev })
+	return &buf
+}
+
+func mustDecode(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := json.NewDecoder(buf).Decode(&m); err != nil {
+		t.Fatalf("invalid json log output: %v", err)
+	}
+	return m
+}
+
+func TestStructured(t *testing.T) {
+	buf := mustCaptureJSON(t)
+	Info(WithRequestID(context.Background(), "abc"), "hello", "domain", "example.org")
+	m := mustDecode(t, buf)
+	for key, want := range map[string]string{
+		"level": "INFO", "msg": "hello", "request_id": "abc", "domain": "example.org",
+	} {
+		if got, ok := m[key]; !ok || got != want {
+			t.Errorf("unexpected value for %q, got %v, wanted %q", key, got, want)
+		}
+	}
+}
+
+func TestStdlibWriter(t *testing.T) {
+	buf := mustCaptureJSON(t)
+	w := stdlibWriter{structured}
+	w.Write([]byte("[WARNING] something failed\n"))
+	m := mustDecode(t, buf)
+	if m["level"] != "WARN" || m["msg"] != "something failed" {
+		t.Errorf("unexpected log output: %v", m)
+	}
+}
+
+func TestFormatText(t *testing.T) {
+	got := formatText(WithRequestID(context.Background(), "abc"), "hello", []any{"domain", "example.org"})
+	if want := "hello request_id=abc domain=example.org"; got != want {
+		t.Errorf("unexpected text, got %q, wanted %q", got, want)
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	// Backend server, e.g., a secondary, echoing the request id it sees.
+	backend := httptest.NewServer(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(RequestID(r.Context())))
+	})))
+	defer backend.Close()
+	client := HTTPClient(nil)
+
+	// Frontend server, e.g., a primary, making a request to the backend.
+	frontend := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, backend.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rsp.Body.Close()
+		var body bytes.Buffer
+		body.ReadFrom(rsp.Body)
+		if got, want := body.String(), RequestID(r.Context()); got != want {
+			t.Errorf("unexpected request id at backend, got %q, wanted %q", got, want)
+		}
+	}))
+
+	for _, table := range []struct {
+		header string
+		want   string // Empty for a generated id
+	}{
+		{"", ""},
+		{"my-id", "my-id"},
+		{"invalid id", ""},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/add-leaf", nil)
+		if table.header != "" {
+			req.Header.Set(RequestIDHeader, table.header)
+		}
+		frontend.ServeHTTP(w, req)
+		got := w.Header().Get(RequestIDHeader)
+		if table.want != "" && got != table.want {
+			t.Errorf("unexpected request id, got %q, wanted %q", got, table.want)
+		}
+		if table.want == "" && (got == "" || got == table.header) {
+			t.Errorf("unexpected request id %q for header %q, wanted a new id", got, table.header)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Header, and grpc metadata key, used to propagate request ids.
+const RequestIDHeader = "X-Request-Id"
+
+// Longest request id accepted from a client.
+const maxRequestIDLength = 64
+
+type requestIDKey struct{}
+
+// NewRequestID returns a new random request id.
+func NewRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// WithRequestID returns a context carrying the given request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id carried by the context, or "" if
+// none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware assigns a request id to each request, taken from the
+// X-Request-Id header if present and valid, otherwise newly
+// generated. The id is added to the request context, and to the
+// response header.
+func Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := WithRequestID(r.Context(), id)
+		Debug(ctx, "handling request", "method", r.Method, "endpoint", r.URL.Path)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type roundTripper struct {
+	next http.RoundTripper
+}
+
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if id := RequestID(req.Context()); id != "" {
+		// RoundTrippers must not modify the request.
+		req = req.Clone(req.Context())
+		req.Header.Set(RequestIDHeader, id)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// HTTPClient returns a client which adds the request id from the
+// request context, if any, as an X-Request-Id header. If c is nil, the
+// default transport is used.
+func HTTPClient(c *http.Client) *http.Client {
+	var client http.Client
+	if c != nil {
+		client = *c
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = roundTripper{next}
+	return &client
+}
+
+// UnaryClientInterceptor adds the request id from the context, if
+// any, as outgoing grpc metadata.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply any,
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if id := RequestID(ctx); id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, RequestIDHeader, id)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
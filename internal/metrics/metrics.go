@@ -2,11 +2,14 @@ package metrics
 
 import (
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/google/trillian/monitoring"
 	"github.com/google/trillian/monitoring/prometheus"
 
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/monitor"
 	"sigsum.org/sigsum-go/pkg/server"
 )
 
@@ -42,3 +45,68 @@ func NewServerMetrics(logID string) server.Metrics {
 			buckets, "logid", "endpoint", "status"),
 	}
 }
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Handler reports requests to h to m, under the given endpoint name,
+// for endpoints not served by the sigsum-go server package.
+func Handler(m server.Metrics, endpoint string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		m.OnRequest(endpoint)
+		rec := statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(&rec, r)
+		m.OnResponse(endpoint, rec.status, time.Since(start))
+	})
+}
+
+type leafCacheMetrics struct {
+	LogID  string
+	leaves monitoring.Counter // number of leaves served, by source
+}
+
+func (m *leafCacheMetrics) OnLeaves(source string, count int) {
+	m.leaves.Add(float64(count), m.LogID, source)
+}
+
+// NewLeafCacheMetrics counts leaves served by the leaf cache, by
+// source: memory, disk or backend. The hit rate is the fraction of
+// leaves not served by the backend.
+func NewLeafCacheMetrics(logID string) db.LeafCacheMetrics {
+	mf := prometheus.MetricFactory{}
+	return &leafCacheMetrics{
+		LogID:  logID,
+		leaves: mf.NewCounter("leaf_cache_leaves", "number of leaves served by the leaf cache", "logid", "source"),
+	}
+}
+
+type monitorMetrics struct {
+	LogID  string
+	alerts monitoring.Counter // number of alerts, by kind
+	size   monitoring.Gauge   // size of verified tree
+}
+
+func (m *monitorMetrics) OnAlert(kind string) {
+	m.alerts.Inc(m.LogID, kind)
+}
+
+func (m *monitorMetrics) OnVerified(size uint64) {
+	m.size.Set(float64(size), m.LogID)
+}
+
+func NewMonitorMetrics(logID string) monitor.Metrics {
+	mf := prometheus.MetricFactory{}
+	return &monitorMetrics{
+		LogID:  logID,
+		alerts: mf.NewCounter("monitor_alerts", "number of monitor alerts", "logid", "kind"),
+		size:   mf.NewGauge("monitor_verified_size", "size of the tree verified by the monitor", "logid"),
+	}
+}
@@ -51,6 +51,20 @@ func (mr *MockStateManagerMockRecorder) CosignedTreeHead() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CosignedTreeHead", reflect.TypeOf((*MockStateManager)(nil).CosignedTreeHead))
 }
 
+// Ready mocks base method.
+func (m *MockStateManager) Ready() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ready")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ready indicates an expected call of Ready.
+func (mr *MockStateManagerMockRecorder) Ready() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ready", reflect.TypeOf((*MockStateManager)(nil).Ready))
+}
+
 // Run mocks base method.
 func (m *MockStateManager) Run(arg0 context.Context, arg1 []policy.Entity, arg2 time.Duration) {
 	m.ctrl.T.Helper()
@@ -76,3 +90,18 @@ func (mr *MockStateManagerMockRecorder) SignedTreeHead() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SignedTreeHead", reflect.TypeOf((*MockStateManager)(nil).SignedTreeHead))
 }
+
+// WaitCosignedTreeHead mocks base method.
+func (m *MockStateManager) WaitCosignedTreeHead(arg0 context.Context, arg1 uint64) (types.CosignedTreeHead, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WaitCosignedTreeHead", arg0, arg1)
+	ret0, _ := ret[0].(types.CosignedTreeHead)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WaitCosignedTreeHead indicates an expected call of WaitCosignedTreeHead.
+func (mr *MockStateManagerMockRecorder) WaitCosignedTreeHead(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WaitCosignedTreeHead", reflect.TypeOf((*MockStateManager)(nil).WaitCosignedTreeHead), arg0, arg1)
+}
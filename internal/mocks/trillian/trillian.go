@@ -1,5 +1,5 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/google/trillian (interfaces: TrillianLogClient)
+// Source: github.com/google/trillian (interfaces: TrillianLogClient,TrillianAdminClient)
 
 // Package trillian is a generated GoMock package.
 package trillian
@@ -215,3 +215,146 @@ func (mr *MockTrillianLogClientMockRecorder) QueueLeaf(arg0, arg1 interface{}, a
 	varargs := append([]interface{}{arg0, arg1}, arg2...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueueLeaf", reflect.TypeOf((*MockTrillianLogClient)(nil).QueueLeaf), varargs...)
 }
+
+// MockTrillianAdminClient is a mock of TrillianAdminClient interface.
+type MockTrillianAdminClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockTrillianAdminClientMockRecorder
+}
+
+// MockTrillianAdminClientMockRecorder is the mock recorder for MockTrillianAdminClient.
+type MockTrillianAdminClientMockRecorder struct {
+	mock *MockTrillianAdminClient
+}
+
+// NewMockTrillianAdminClient creates a new mock instance.
+func NewMockTrillianAdminClient(ctrl *gomock.Controller) *MockTrillianAdminClient {
+	mock := &MockTrillianAdminClient{ctrl: ctrl}
+	mock.recorder = &MockTrillianAdminClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTrillianAdminClient) EXPECT() *MockTrillianAdminClientMockRecorder {
+	return m.recorder
+}
+
+// CreateTree mocks base method.
+func (m *MockTrillianAdminClient) CreateTree(arg0 context.Context, arg1 *trillian.CreateTreeRequest, arg2 ...grpc.CallOption) (*trillian.Tree, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "CreateTree", varargs...)
+	ret0, _ := ret[0].(*trillian.Tree)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateTree indicates an expected call of CreateTree.
+func (mr *MockTrillianAdminClientMockRecorder) CreateTree(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTree", reflect.TypeOf((*MockTrillianAdminClient)(nil).CreateTree), varargs...)
+}
+
+// DeleteTree mocks base method.
+func (m *MockTrillianAdminClient) DeleteTree(arg0 context.Context, arg1 *trillian.DeleteTreeRequest, arg2 ...grpc.CallOption) (*trillian.Tree, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "DeleteTree", varargs...)
+	ret0, _ := ret[0].(*trillian.Tree)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteTree indicates an expected call of DeleteTree.
+func (mr *MockTrillianAdminClientMockRecorder) DeleteTree(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTree", reflect.TypeOf((*MockTrillianAdminClient)(nil).DeleteTree), varargs...)
+}
+
+// GetTree mocks base method.
+func (m *MockTrillianAdminClient) GetTree(arg0 context.Context, arg1 *trillian.GetTreeRequest, arg2 ...grpc.CallOption) (*trillian.Tree, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetTree", varargs...)
+	ret0, _ := ret[0].(*trillian.Tree)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTree indicates an expected call of GetTree.
+func (mr *MockTrillianAdminClientMockRecorder) GetTree(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTree", reflect.TypeOf((*MockTrillianAdminClient)(nil).GetTree), varargs...)
+}
+
+// ListTrees mocks base method.
+func (m *MockTrillianAdminClient) ListTrees(arg0 context.Context, arg1 *trillian.ListTreesRequest, arg2 ...grpc.CallOption) (*trillian.ListTreesResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ListTrees", varargs...)
+	ret0, _ := ret[0].(*trillian.ListTreesResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListTrees indicates an expected call of ListTrees.
+func (mr *MockTrillianAdminClientMockRecorder) ListTrees(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTrees", reflect.TypeOf((*MockTrillianAdminClient)(nil).ListTrees), varargs...)
+}
+
+// UndeleteTree mocks base method.
+func (m *MockTrillianAdminClient) UndeleteTree(arg0 context.Context, arg1 *trillian.UndeleteTreeRequest, arg2 ...grpc.CallOption) (*trillian.Tree, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "UndeleteTree", varargs...)
+	ret0, _ := ret[0].(*trillian.Tree)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UndeleteTree indicates an expected call of UndeleteTree.
+func (mr *MockTrillianAdminClientMockRecorder) UndeleteTree(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UndeleteTree", reflect.TypeOf((*MockTrillianAdminClient)(nil).UndeleteTree), varargs...)
+}
+
+// UpdateTree mocks base method.
+func (m *MockTrillianAdminClient) UpdateTree(arg0 context.Context, arg1 *trillian.UpdateTreeRequest, arg2 ...grpc.CallOption) (*trillian.Tree, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "UpdateTree", varargs...)
+	ret0, _ := ret[0].(*trillian.Tree)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateTree indicates an expected call of UpdateTree.
+func (mr *MockTrillianAdminClientMockRecorder) UpdateTree(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTree", reflect.TypeOf((*MockTrillianAdminClient)(nil).UpdateTree), varargs...)
+}
@@ -0,0 +1,83 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sigsum.org/log-go/internal/logging"
+)
+
+// Kinds of alerts.
+const (
+	// A leaf signed by a watched key.
+	AlertWatchedKey = "watched-key"
+	// Invalid log signature on a tree head.
+	AlertLogSignature = "log-signature"
+	// Tree head not sufficiently cosigned according to policy.
+	AlertCosignatures = "cosignatures"
+	// Tree head inconsistent with previous tree heads or leaves.
+	AlertInconsistent = "inconsistent"
+)
+
+// Timeout for delivering an alert to the webhook.
+const webhookTimeout = 10 * time.Second
+
+// Alert is posted, JSON encoded, to the webhook.
+type Alert struct {
+	Kind     string `json:"kind"`
+	Message  string `json:"message"`
+	TreeSize uint64 `json:"tree_size"`
+	// Set only for watched-key alerts.
+	LeafIndex *uint64 `json:"leaf_index,omitempty"`
+	KeyHash   string  `json:"key_hash,omitempty"`
+	Checksum  string  `json:"checksum,omitempty"`
+}
+
+// Alerter delivers alerts, by logging them, and, if WebhookURL is
+// set, by posting them to the webhook.
+type Alerter struct {
+	WebhookURL string
+	HTTPClient *http.Client // if nil, http.DefaultClient is used
+}
+
+func (a *Alerter) Alert(ctx context.Context, alert *Alert) {
+	logging.Warning(ctx, "monitor alert", "kind", alert.Kind, "message", alert.Message,
+		"tree_size", alert.TreeSize)
+	if a.WebhookURL == "" {
+		return
+	}
+	if err := a.post(ctx, alert); err != nil {
+		logging.Error(ctx, "delivering alert to webhook failed", "error", err)
+	}
+}
+
+func (a *Alerter) post(ctx context.Context, alert *Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := a.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook responded with status %d", rsp.StatusCode)
+	}
+	return nil
+}
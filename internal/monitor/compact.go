@@ -0,0 +1,117 @@
+package monitor
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"git.glasklar.is/sigsum/dependencies/safefile"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+)
+
+// A compact range covering the leaves [0, size), i.e., the root
+// hashes of the maximal perfect subtrees, from left to right. It is
+// all that is needed to extend the tree with new leaves and compute
+// the new root hash.
+type compactRange struct {
+	size  uint64
+	nodes []crypto.Hash
+}
+
+func (r *compactRange) add(leafHash *crypto.Hash) {
+	r.nodes = append(r.nodes, *leafHash)
+	// Each trailing one bit of the old size corresponds to a
+	// perfect subtree to merge with.
+	for s := r.size; s&1 == 1; s >>= 1 {
+		n := len(r.nodes)
+		r.nodes = append(r.nodes[:n-2], merkle.HashInteriorNode(&r.nodes[n-2], &r.nodes[n-1]))
+	}
+	r.size++
+}
+
+func (r *compactRange) rootHash() crypto.Hash {
+	if len(r.nodes) == 0 {
+		return merkle.HashEmptyTree()
+	}
+	root := r.nodes[len(r.nodes)-1]
+	for i := len(r.nodes) - 2; i >= 0; i-- {
+		root = merkle.HashInteriorNode(&r.nodes[i], &root)
+	}
+	return root
+}
+
+func (r *compactRange) clone() compactRange {
+	return compactRange{size: r.size, nodes: append([]crypto.Hash(nil), r.nodes...)}
+}
+
+// Reads a compact range stored by writeState, with lines "size=..."
+// and "node=...".
+func readState(name string) (compactRange, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return compactRange{}, err
+	}
+	defer f.Close()
+	return parseState(f)
+}
+
+func parseState(r io.Reader) (compactRange, error) {
+	var state compactRange
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return compactRange{}, fmt.Errorf("missing size")
+	}
+	s, ok := strings.CutPrefix(scanner.Text(), "size=")
+	if !ok {
+		return compactRange{}, fmt.Errorf("missing size")
+	}
+	size, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return compactRange{}, fmt.Errorf("invalid size: %v", err)
+	}
+	for scanner.Scan() {
+		s, ok := strings.CutPrefix(scanner.Text(), "node=")
+		b, err := hex.DecodeString(s)
+		if !ok || err != nil || len(b) != crypto.HashSize {
+			return compactRange{}, fmt.Errorf("invalid line %q", scanner.Text())
+		}
+		var node crypto.Hash
+		copy(node[:], b)
+		state.nodes = append(state.nodes, node)
+	}
+	if err := scanner.Err(); err != nil {
+		return compactRange{}, err
+	}
+	// One node per one bit of the size.
+	ones := 0
+	for s := size; s > 0; s >>= 1 {
+		ones += int(s & 1)
+	}
+	if ones != len(state.nodes) {
+		return compactRange{}, fmt.Errorf("got %d nodes for size %d", len(state.nodes), size)
+	}
+	state.size = size
+	return state, nil
+}
+
+func writeState(name string, state *compactRange) error {
+	f, err := safefile.Create(name, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "size=%d\n", state.size); err != nil {
+		return err
+	}
+	for _, node := range state.nodes {
+		if _, err := fmt.Fprintf(f, "node=%x\n", node[:]); err != nil {
+			return err
+		}
+	}
+	return f.Commit()
+}
@@ -0,0 +1,219 @@
+// Package monitor implements a monitor, following a log via its
+// public api. It verifies that successive cosigned tree heads are
+// properly signed and consistent, and alerts on leaves signed by
+// watched keys.
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"sigsum.org/log-go/internal/logging"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Used if no BatchSize is configured.
+const defaultBatchSize = 512
+
+type Metrics interface {
+	// Called for each alert.
+	OnAlert(kind string)
+	// Called when leaves up to size have been verified.
+	OnVerified(size uint64)
+}
+
+// Monitor follows a single log.
+type Monitor struct {
+	Log         api.Log                          // the log's public api
+	LogKey      crypto.PublicKey                 // the log's public key
+	Policy      *policy.Policy                   // if non-nil, used to verify cosignatures
+	WatchedKeys map[crypto.Hash]crypto.PublicKey // by key hash
+	BatchSize   int                              // number of leaves to ask for per get-leaves request
+	StateFile   string                           // if non-empty, where verified state is persisted
+	Alerter     *Alerter
+	Metrics     Metrics // may be nil
+
+	// Covers the leaves verified so far. Only accessed by the Run
+	// goroutine.
+	state compactRange
+}
+
+// Run checks the log at the given interval, until the context is
+// cancelled.
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) error {
+	if m.StateFile != "" {
+		state, err := readState(m.StateFile)
+		if err == nil {
+			m.state = state
+			logging.Info(ctx, "loaded monitor state", "size", state.size)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("loading monitor state failed: %v", err)
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := m.check(ctx); err != nil {
+			logging.Warning(ctx, "monitor check failed", "error", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (m *Monitor) alert(ctx context.Context, alert *Alert) {
+	if m.Metrics != nil {
+		m.Metrics.OnAlert(alert.Kind)
+	}
+	if m.Alerter != nil {
+		m.Alerter.Alert(ctx, alert)
+	}
+}
+
+// Fetches the log's current tree head, and verifies it, and any new
+// leaves. Returns an error for failures to talk to the log, problems
+// with the log itself result in alerts.
+func (m *Monitor) check(ctx context.Context) error {
+	cth, err := m.Log.GetTreeHead(ctx)
+	if err != nil {
+		return fmt.Errorf("get-tree-head failed: %v", err)
+	}
+	if !cth.SignedTreeHead.Verify(&m.LogKey) {
+		m.alert(ctx, &Alert{Kind: AlertLogSignature, Message: "invalid log signature on tree head", TreeSize: cth.Size})
+		return nil
+	}
+	if m.Policy != nil {
+		logKeyHash := crypto.HashBytes(m.LogKey[:])
+		if err := m.Policy.VerifyCosignedTreeHead(&logKeyHash, &cth); err != nil {
+			m.alert(ctx, &Alert{Kind: AlertCosignatures, Message: err.Error(), TreeSize: cth.Size})
+			return nil
+		}
+	}
+	oldTree := types.TreeHead{Size: m.state.size, RootHash: m.state.rootHash()}
+	switch {
+	case cth.Size < oldTree.Size:
+		m.alert(ctx, &Alert{Kind: AlertInconsistent, TreeSize: cth.Size,
+			Message: fmt.Sprintf("tree head smaller than previously verified size %d", oldTree.Size)})
+		return nil
+	case cth.Size == oldTree.Size:
+		if cth.RootHash != oldTree.RootHash {
+			m.alert(ctx, &Alert{Kind: AlertInconsistent, TreeSize: cth.Size,
+				Message: "root hash differs from previously verified tree of the same size"})
+		}
+		return nil
+	case oldTree.Size > 0:
+		proof, err := m.Log.GetConsistencyProof(ctx, requests.ConsistencyProof{
+			OldSize: oldTree.Size, NewSize: cth.Size})
+		if err != nil {
+			return fmt.Errorf("get-consistency-proof failed: %v", err)
+		}
+		if err := proof.Verify(&oldTree, &cth.TreeHead); err != nil {
+			m.alert(ctx, &Alert{Kind: AlertInconsistent, TreeSize: cth.Size,
+				Message: fmt.Sprintf("tree head not consistent with previous size %d: %v", oldTree.Size, err)})
+			return nil
+		}
+	}
+	return m.checkLeaves(ctx, &cth.TreeHead)
+}
+
+// Fetches the leaves added since the previous tree head, in batches
+// aligned to multiples of BatchSize, and checks that they match the
+// given tree head. After each batch, the leaves so far are verified
+// against the tree head, using a consistency proof, and the verified
+// state is updated. Alerts for leaves signed by watched keys are sent
+// only after the state covering them has been stored, so that they
+// aren't repeated if a later batch fails.
+func (m *Monitor) checkLeaves(ctx context.Context, th *types.TreeHead) error {
+	batchSize := uint64(m.BatchSize)
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+	for m.state.size < th.Size {
+		state := m.state.clone()
+		leaves, err := m.Log.GetLeaves(ctx, requests.Leaves{
+			StartIndex: state.size,
+			EndIndex:   min((state.size/batchSize+1)*batchSize, th.Size),
+		})
+		if err != nil {
+			return fmt.Errorf("get-leaves failed: %v", err)
+		}
+		if len(leaves) == 0 {
+			return fmt.Errorf("get-leaves returned no leaves")
+		}
+		start := state.size
+		for i := range leaves {
+			leafHash := merkle.HashLeafNode(leaves[i].ToBinary())
+			state.add(&leafHash)
+		}
+		if ok, err := m.verifyState(ctx, &state, th); err != nil || !ok {
+			return err
+		}
+		if m.StateFile != "" {
+			if err := writeState(m.StateFile, &state); err != nil {
+				return fmt.Errorf("storing monitor state failed: %v", err)
+			}
+		}
+		m.state = state
+		if m.Metrics != nil {
+			m.Metrics.OnVerified(state.size)
+		}
+		for i := range leaves {
+			m.checkLeaf(ctx, &leaves[i], start+uint64(i), th.Size)
+		}
+	}
+	return nil
+}
+
+// Checks that the leaves covered by state are the first leaves of
+// the tree th. Returns false, after alerting, if they aren't.
+func (m *Monitor) verifyState(ctx context.Context, state *compactRange, th *types.TreeHead) (bool, error) {
+	if state.size == th.Size {
+		if state.rootHash() != th.RootHash {
+			m.alert(ctx, &Alert{Kind: AlertInconsistent, TreeSize: th.Size,
+				Message: "leaves don't match root hash of tree head"})
+			return false, nil
+		}
+		return true, nil
+	}
+	proof, err := m.Log.GetConsistencyProof(ctx, requests.ConsistencyProof{
+		OldSize: state.size, NewSize: th.Size})
+	if err != nil {
+		return false, fmt.Errorf("get-consistency-proof failed: %v", err)
+	}
+	if err := proof.Verify(&types.TreeHead{Size: state.size, RootHash: state.rootHash()}, th); err != nil {
+		m.alert(ctx, &Alert{Kind: AlertInconsistent, TreeSize: th.Size,
+			Message: fmt.Sprintf("leaves up to index %d don't match tree head: %v", state.size, err)})
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *Monitor) checkLeaf(ctx context.Context, leaf *types.Leaf, index, treeSize uint64) {
+	pub, ok := m.WatchedKeys[leaf.KeyHash]
+	if !ok {
+		return
+	}
+	msg := "leaf signed by watched key"
+	if !leaf.Verify(&pub) {
+		msg = "leaf with invalid signature by watched key"
+	}
+	m.alert(ctx, &Alert{
+		Kind:      AlertWatchedKey,
+		Message:   msg,
+		TreeSize:  treeSize,
+		LeafIndex: &index,
+		KeyHash:   fmt.Sprintf("%x", leaf.KeyHash[:]),
+		Checksum:  fmt.Sprintf("%x", leaf.Checksum[:]),
+	})
+}
@@ -0,0 +1,267 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestCompactRange(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "state")
+	var r compactRange
+	tree := merkle.NewTree()
+	for i := 0; i < 20; i++ {
+		if got, want := r.rootHash(), tree.GetRootHash(); got != want {
+			t.Errorf("size %d: got root %x, want %x", i, got, want)
+		}
+		if err := writeState(name, &r); err != nil {
+			t.Fatalf("size %d: writeState failed: %v", i, err)
+		}
+		state, err := readState(name)
+		if err != nil {
+			t.Fatalf("size %d: readState failed: %v", i, err)
+		}
+		if state.size != r.size || state.rootHash() != r.rootHash() {
+			t.Errorf("size %d: state not preserved", i)
+		}
+		h := crypto.Hash{byte(i)}
+		r.add(&h)
+		tree.AddLeafHash(&h)
+	}
+}
+
+// Serves a tree from a memory db, signed by signer, or treeHead, if
+// set. If failFrom is non-zero, get-leaves fails from that index.
+type fakeLog struct {
+	api.Log
+	db       db.Client
+	signer   crypto.Signer
+	treeHead *types.TreeHead
+	failFrom uint64
+}
+
+func (l *fakeLog) GetTreeHead(ctx context.Context) (types.CosignedTreeHead, error) {
+	th, err := l.db.GetTreeHead(ctx)
+	if err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	if l.treeHead != nil {
+		th = *l.treeHead
+	}
+	sth, err := th.Sign(l.signer)
+	return types.CosignedTreeHead{SignedTreeHead: sth}, err
+}
+
+func (l *fakeLog) GetConsistencyProof(ctx context.Context, req requests.ConsistencyProof) (types.ConsistencyProof, error) {
+	return l.db.GetConsistencyProof(ctx, &req)
+}
+
+func (l *fakeLog) GetLeaves(ctx context.Context, req requests.Leaves) ([]types.Leaf, error) {
+	if l.failFrom > 0 && req.StartIndex >= l.failFrom {
+		return nil, fmt.Errorf("mock get-leaves failure")
+	}
+	// Exercise handling of short responses.
+	req.EndIndex = min(req.EndIndex, req.StartIndex+3)
+	return l.db.GetLeaves(ctx, &req)
+}
+
+// Collects alerts posted to the webhook.
+type webhook struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var alert Alert
+	if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.alerts = append(h.alerts, alert)
+}
+
+func (h *webhook) take() []Alert {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	alerts := h.alerts
+	h.alerts = nil
+	return alerts
+}
+
+func newLeaves(start, n int) []types.Leaf {
+	leaves := make([]types.Leaf, n)
+	for i := range leaves {
+		leaves[i].Checksum = crypto.Hash{byte(start + i), 1}
+	}
+	return leaves
+}
+
+func TestMonitor(t *testing.T) {
+	ctx := context.Background()
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{1})
+	watchedSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	watchedPub := watchedSigner.Public()
+
+	memDb := db.NewMemoryDb()
+	leaves := newLeaves(0, 5)
+	leaves[3].KeyHash = crypto.HashBytes(watchedPub[:])
+	sig, err := types.SignLeafChecksum(watchedSigner, &leaves[3].Checksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves[3].Signature = sig
+	if err := memDb.AddSequencedLeaves(ctx, leaves, 0); err != nil {
+		t.Fatalf("AddSequencedLeaves failed: %v", err)
+	}
+
+	hook := webhook{}
+	server := httptest.NewServer(&hook)
+	defer server.Close()
+
+	log := fakeLog{db: memDb, signer: logSigner}
+	stateFile := filepath.Join(t.TempDir(), "state")
+	m := Monitor{
+		Log:         &log,
+		LogKey:      logSigner.Public(),
+		WatchedKeys: map[crypto.Hash]crypto.PublicKey{leaves[3].KeyHash: watchedPub},
+		StateFile:   stateFile,
+		Alerter:     &Alerter{WebhookURL: server.URL},
+	}
+
+	if err := m.check(ctx); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	alerts := hook.take()
+	if len(alerts) != 1 || alerts[0].Kind != AlertWatchedKey ||
+		alerts[0].LeafIndex == nil || *alerts[0].LeafIndex != 3 ||
+		alerts[0].Message != "leaf signed by watched key" {
+		t.Errorf("unexpected alerts: %#v", alerts)
+	}
+	if m.state.size != 5 {
+		t.Errorf("got verified size %d, want 5", m.state.size)
+	}
+
+	// New leaves, no alerts.
+	if err := memDb.AddSequencedLeaves(ctx, newLeaves(5, 3), 5); err != nil {
+		t.Fatalf("AddSequencedLeaves failed: %v", err)
+	}
+	if err := m.check(ctx); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if alerts := hook.take(); len(alerts) != 0 {
+		t.Errorf("unexpected alerts: %#v", alerts)
+	}
+	if state, err := readState(stateFile); err != nil || state.size != 8 {
+		t.Errorf("unexpected stored state, size %d, err %v", state.size, err)
+	}
+
+	for _, table := range []struct {
+		desc     string
+		signer   crypto.Signer
+		treeHead *types.TreeHead
+		want     string
+	}{
+		{"bad signature", crypto.NewEd25519Signer(&crypto.PrivateKey{3}), nil, AlertLogSignature},
+		{"fork", logSigner, &types.TreeHead{Size: 8, RootHash: crypto.Hash{1}}, AlertInconsistent},
+		{"shrunk", logSigner, &types.TreeHead{Size: 7, RootHash: crypto.Hash{1}}, AlertInconsistent},
+		{"wrong root", logSigner, &types.TreeHead{Size: 9, RootHash: crypto.Hash{1}}, AlertInconsistent},
+	} {
+		log.signer, log.treeHead = table.signer, table.treeHead
+		if table.desc == "wrong root" {
+			if err := memDb.AddSequencedLeaves(ctx, newLeaves(8, 1), 8); err != nil {
+				t.Fatalf("AddSequencedLeaves failed: %v", err)
+			}
+		}
+		if err := m.check(ctx); err != nil {
+			t.Errorf("%s: check failed: %v", table.desc, err)
+		}
+		if alerts := hook.take(); len(alerts) != 1 || alerts[0].Kind != table.want {
+			t.Errorf("%s: unexpected alerts: %#v", table.desc, alerts)
+		}
+		if m.state.size != 8 {
+			t.Errorf("%s: got verified size %d, want 8", table.desc, m.state.size)
+		}
+	}
+}
+
+func TestMonitorBatches(t *testing.T) {
+	ctx := context.Background()
+	logSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{1})
+	watchedSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{2})
+	watchedPub := watchedSigner.Public()
+	keyHash := crypto.HashBytes(watchedPub[:])
+
+	memDb := db.NewMemoryDb()
+	leaves := newLeaves(0, 8)
+	for _, i := range []int{1, 4} {
+		leaves[i].KeyHash = keyHash
+	}
+	if err := memDb.AddSequencedLeaves(ctx, leaves, 0); err != nil {
+		t.Fatalf("AddSequencedLeaves failed: %v", err)
+	}
+
+	hook := webhook{}
+	server := httptest.NewServer(&hook)
+	defer server.Close()
+
+	// Fails after the first batch.
+	log := fakeLog{db: memDb, signer: logSigner, failFrom: 2}
+	stateFile := filepath.Join(t.TempDir(), "state")
+	m := Monitor{
+		Log:         &log,
+		LogKey:      logSigner.Public(),
+		WatchedKeys: map[crypto.Hash]crypto.PublicKey{keyHash: watchedPub},
+		BatchSize:   2,
+		StateFile:   stateFile,
+		Alerter:     &Alerter{WebhookURL: server.URL},
+	}
+	leafIndices := func(alerts []Alert) []uint64 {
+		var indices []uint64
+		for _, alert := range alerts {
+			if alert.Kind != AlertWatchedKey || alert.LeafIndex == nil {
+				t.Errorf("unexpected alert: %#v", alert)
+				continue
+			}
+			indices = append(indices, *alert.LeafIndex)
+		}
+		return indices
+	}
+
+	if err := m.check(ctx); err == nil {
+		t.Fatalf("check unexpectedly succeeded")
+	}
+	// The first batch is verified and stored, and alerted on.
+	if state, err := readState(stateFile); err != nil || state.size != 2 {
+		t.Errorf("unexpected stored state, size %d, err %v", state.size, err)
+	}
+	if got, want := leafIndices(hook.take()), []uint64{1}; !slices.Equal(got, want) {
+		t.Errorf("unexpected alerts for leaves %v, want %v", got, want)
+	}
+
+	// Continues where it failed, without repeating alerts.
+	log.failFrom = 0
+	if err := m.check(ctx); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if state, err := readState(stateFile); err != nil || state.size != 8 {
+		t.Errorf("unexpected stored state, size %d, err %v", state.size, err)
+	}
+	if got, want := leafIndices(hook.take()), []uint64{4}; !slices.Equal(got, want) {
+		t.Errorf("unexpected alerts for leaves %v, want %v", got, want)
+	}
+}
@@ -4,30 +4,51 @@ package primary
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/http"
+	"time"
 
+	"sigsum.org/log-go/internal/audit"
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/logging"
+	"sigsum.org/log-go/internal/tracing"
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/merkle"
 	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/submit-token"
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
 func (p Primary) AddLeaf(ctx context.Context, req requests.Leaf, t *token.SubmitHeader) (bool, error) {
-	log.Debug("handling add-leaf request")
+	ctx, span := tracing.Start(ctx, "primary.AddLeaf")
+	defer span.End()
+
 	var domain *string
 	if t != nil && p.TokenVerifier != nil {
+		verifyCtx, verifySpan := tracing.Start(ctx, "token.Verify", "domain", t.Domain)
+		err := p.TokenVerifier.Verify(verifyCtx, t)
+		verifySpan.SetError(err)
+		verifySpan.End()
 		// TODO: Return more appropriate errors from TokenVerifier?
-		if err := p.TokenVerifier.Verify(ctx, t); err != nil {
+		if err != nil {
 			return false, api.ErrBadRequest.WithError(err)
 		}
 		domain = &t.Domain
 	}
 	keyHash := crypto.HashBytes(req.PublicKey[:])
-	relax := p.RateLimiter.AccessAllowed(domain, &keyHash)
+	span.SetAttributes("key_hash", hex.EncodeToString(keyHash[:]))
+	logArgs := []any{"endpoint", "add-leaf", "key_hash", hex.EncodeToString(keyHash[:])}
+	if domain != nil {
+		logArgs = append(logArgs, "domain", *domain)
+	}
+	logging.Debug(ctx, "handling add-leaf request", logArgs...)
+	relax, bucket := p.RateLimiter.AccessAllowed(domain, &keyHash)
 	if relax == nil {
+		logging.Info(ctx, "add-leaf request rate limited", append(logArgs, "bucket", bucket)...)
 		if domain == nil {
 			return false, api.ErrTooManyRequests.WithError(fmt.Errorf("rate-limit for unknown domain exceeded"))
 		}
@@ -38,19 +59,177 @@ func (p Primary) AddLeaf(ctx context.Context, req requests.Leaf, t *token.Submit
 		return false, api.ErrForbidden.WithError(err)
 	}
 
+	leafHash := merkle.HashLeafNode(leaf.ToBinary())
+	logArgs = append(logArgs, "leaf_hash", hex.EncodeToString(leafHash[:]))
+	span.SetAttributes("leaf_hash", hex.EncodeToString(leafHash[:]))
+
 	sth := p.Stateman.SignedTreeHead()
 	status, err := p.DbClient.AddLeaf(ctx,
 		&leaf, sth.Size)
-	log.Debug("status: %#v, err: %v", status, err)
 	if err != nil {
+		logging.Warning(ctx, "adding leaf failed", append(logArgs, "error", err)...)
+		span.SetError(err)
 		return false, err
 	}
+	span.SetAttributes("already_exists", status.AlreadyExists, "sequenced", status.IsSequenced)
+	logging.Debug(ctx, "added leaf", append(logArgs,
+		"already_exists", status.AlreadyExists, "sequenced", status.IsSequenced)...)
 	if status.AlreadyExists {
 		relax()
+	} else if p.AuditLog != nil {
+		record := audit.Record{
+			Time:            time.Now().UTC(),
+			LeafHash:        hex.EncodeToString(leafHash[:]),
+			KeyHash:         hex.EncodeToString(keyHash[:]),
+			ClientAddr:      audit.ClientAddr(ctx),
+			RateLimitBucket: bucket,
+		}
+		if domain != nil {
+			record.Domain = *domain
+		}
+		// The leaf is already accepted, so don't fail the request.
+		if err := p.AuditLog.Write(&record); err != nil {
+			logging.Error(ctx, "writing audit log failed", append(logArgs, "error", err)...)
+		}
 	}
 	return status.IsSequenced, nil
 }
 
+// Returns the inclusion proof for leafHash in the given published
+// tree, or db.ErrNotIncluded.
+func (p Primary) publishedInclusionProof(ctx context.Context, leafHash *crypto.Hash, cth *types.CosignedTreeHead) (types.InclusionProof, error) {
+	switch cth.Size {
+	case 0:
+		return types.InclusionProof{}, db.ErrNotIncluded
+	case 1:
+		// The backend can't produce an empty proof, but
+		// then the root hash is the leaf hash.
+		if cth.RootHash != *leafHash {
+			return types.InclusionProof{}, db.ErrNotIncluded
+		}
+		return types.InclusionProof{}, nil
+	}
+	return p.DbClient.GetInclusionProof(ctx, &requests.InclusionProof{Size: cth.Size, LeafHash: *leafHash})
+}
+
+// Looks up leafHash in the backend tree, which may be larger than
+// the published tree. Returns the inclusion proof and the tree size
+// it is for, or db.ErrNotIncluded.
+func (p Primary) lookupLeaf(ctx context.Context, leafHash *crypto.Hash, cth *types.CosignedTreeHead) (types.InclusionProof, uint64, error) {
+	size := cth.Size
+	if p.BackendSize != nil {
+		size = max(size, p.BackendSize.Get())
+	}
+	if size == cth.Size {
+		proof, err := p.publishedInclusionProof(ctx, leafHash, cth)
+		return proof, size, err
+	}
+	if size == 1 {
+		// As for a published tree of size 1, the backend
+		// can't produce an empty proof, so compare with the
+		// backend's root hash instead.
+		th, err := p.DbClient.GetTreeHead(ctx)
+		if err != nil {
+			return types.InclusionProof{}, 0, err
+		}
+		if th.Size == 1 {
+			if th.RootHash != *leafHash {
+				return types.InclusionProof{}, 0, db.ErrNotIncluded
+			}
+			return types.InclusionProof{}, 1, nil
+		}
+		size = th.Size
+	}
+	proof, err := p.DbClient.GetInclusionProof(ctx, &requests.InclusionProof{Size: size, LeafHash: *leafHash})
+	return proof, size, err
+}
+
+func writeInclusion(ctx context.Context, w http.ResponseWriter, cth *types.CosignedTreeHead, proof *types.InclusionProof) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := cth.ToASCII(w); err != nil {
+		logging.Warning(ctx, "writing wait-inclusion response failed", "error", err)
+		return
+	}
+	if err := proof.ToASCII(w); err != nil {
+		logging.Warning(ctx, "writing wait-inclusion response failed", "error", err)
+	}
+}
+
+// WaitInclusion is a long-poll endpoint, which blocks until the leaf
+// with the hash in the request is included in the published cosigned
+// tree head, or until the request times out. The response is the
+// cosigned tree head, followed by the inclusion proof, in the same
+// formats as for get-tree-head and get-inclusion-proof. On timeout,
+// the response is 504 Gateway Timeout, and the client is expected to
+// retry.
+//
+// Once the leaf is found in the backend tree, its index is
+// remembered, and the backend isn't queried again until a published
+// tree head includes it.
+func (p Primary) WaitInclusion(w http.ResponseWriter, r *http.Request) {
+	var leafHash crypto.Hash
+	b, err := hex.DecodeString(r.PathValue("hash"))
+	if err != nil || len(b) != len(leafHash) {
+		http.Error(w, "invalid leaf hash", http.StatusBadRequest)
+		return
+	}
+	copy(leafHash[:], b)
+	ctx := r.Context()
+	if p.WaitInclusionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.WaitInclusionTimeout)
+		defer cancel()
+	}
+	ctx, span := tracing.Start(ctx, "primary.WaitInclusion", "leaf_hash", hex.EncodeToString(leafHash[:]))
+	defer span.End()
+	logging.Debug(ctx, "handling wait-inclusion request", "endpoint", "wait-inclusion",
+		"leaf_hash", hex.EncodeToString(leafHash[:]))
+
+	backendFailure := func(err error) {
+		span.SetError(err)
+		logging.Warning(ctx, "wait-inclusion failed", "error", err)
+		http.Error(w, "backend failure", http.StatusInternalServerError)
+	}
+
+	cth := p.Stateman.CosignedTreeHead()
+	found := false
+	var index uint64
+	for {
+		if !found {
+			proof, size, err := p.lookupLeaf(ctx, &leafHash, &cth)
+			switch {
+			case err == nil && size == cth.Size:
+				writeInclusion(ctx, w, &cth, &proof)
+				return
+			case err == nil:
+				found, index = true, proof.LeafIndex
+			case !errors.Is(err, db.ErrNotIncluded):
+				backendFailure(err)
+				return
+			}
+		}
+		if found && index < cth.Size {
+			proof, err := p.publishedInclusionProof(ctx, &leafHash, &cth)
+			if err != nil {
+				backendFailure(err)
+				return
+			}
+			writeInclusion(ctx, w, &cth, &proof)
+			return
+		}
+		// The leaf can only be included in a larger tree.
+		waitSize := cth.Size
+		if found {
+			waitSize = index
+		}
+		cth, err = p.Stateman.WaitCosignedTreeHead(ctx, waitSize)
+		if err != nil {
+			http.Error(w, "leaf not yet included in published tree head, retry", http.StatusGatewayTimeout)
+			return
+		}
+	}
+}
+
 func (p Primary) GetTreeHead(_ context.Context) (types.CosignedTreeHead, error) {
 	log.Debug("handling get-tree-head request")
 	return p.Stateman.CosignedTreeHead(), nil
@@ -58,33 +237,58 @@ func (p Primary) GetTreeHead(_ context.Context) (types.CosignedTreeHead, error)
 
 func (p Primary) GetConsistencyProof(ctx context.Context, req requests.ConsistencyProof) (types.ConsistencyProof, error) {
 	log.Debug("handling get-consistency-proof request")
+	ctx, span := tracing.Start(ctx, "primary.GetConsistencyProof",
+		"old_size", req.OldSize, "new_size", req.NewSize)
+	defer span.End()
+
 	curTree := p.Stateman.CosignedTreeHead()
 	if req.NewSize > curTree.TreeHead.Size {
 		return types.ConsistencyProof{}, api.ErrBadRequest.WithError(fmt.Errorf("new_size %d outside of current tree, size %d",
 			req.NewSize, curTree.TreeHead.Size))
 	}
 
-	return p.DbClient.GetConsistencyProof(ctx, &req)
+	if proof, ok := p.ConsistencyProofs.Get(req); ok {
+		span.SetAttributes("cached", true)
+		return proof, nil
+	}
+	proof, err := p.DbClient.GetConsistencyProof(ctx, &req)
+	if err == nil {
+		p.ConsistencyProofs.Add(req, proof)
+	}
+	return proof, err
 }
 
 func (p Primary) GetInclusionProof(ctx context.Context, req requests.InclusionProof) (types.InclusionProof, error) {
 	log.Debug("handling get-inclusion-proof request")
+	ctx, span := tracing.Start(ctx, "primary.GetInclusionProof", "size", req.Size)
+	defer span.End()
+
 	curTree := p.Stateman.CosignedTreeHead()
 	if req.Size > curTree.TreeHead.Size {
 		return types.InclusionProof{}, api.ErrBadRequest.WithError(fmt.Errorf("tree_size outside of current tree"))
 	}
 
+	if proof, ok := p.InclusionProofs.Get(req); ok {
+		span.SetAttributes("cached", true)
+		return proof, nil
+	}
 	proof, err := p.DbClient.GetInclusionProof(ctx, &req)
 	// TODO: Make DbClient return the appropriate api error?
 	if err == db.ErrNotIncluded {
 		err = api.ErrNotFound
 	}
+	if err == nil {
+		p.InclusionProofs.Add(req, proof)
+	}
 	return proof, err
 }
 
 func (p Primary) getLeavesGeneral(ctx context.Context, req requests.Leaves,
 	maxIndex uint64, strictEnd bool) ([]types.Leaf, error) {
 	log.Debug("handling get-leaves request")
+	ctx, span := tracing.Start(ctx, "primary.GetLeaves",
+		"start_index", req.StartIndex, "end_index", req.EndIndex)
+	defer span.End()
 
 	// When invoked via sigsum-go/pkg/server, this error is
 	// already checked for earlier and will not happen here.
@@ -2,14 +2,23 @@ package primary
 
 import (
 	"context"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	"sigsum.org/log-go/internal/audit"
+	"sigsum.org/log-go/internal/cache"
 	"sigsum.org/log-go/internal/db"
 	mocksDB "sigsum.org/log-go/internal/mocks/db"
 	mocksState "sigsum.org/log-go/internal/mocks/state"
+	"sigsum.org/log-go/internal/notify"
 	"sigsum.org/log-go/internal/rate-limit"
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
@@ -74,6 +83,51 @@ func TestAddLeaf(t *testing.T) {
 	}
 }
 
+func TestAddLeafAuditLog(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	client := mocksDB.NewMockClient(ctrl)
+	gomock.InOrder(
+		client.EXPECT().AddLeaf(gomock.Any(), gomock.Any(), gomock.Any()).Return(db.AddLeafStatus{}, nil),
+		client.EXPECT().AddLeaf(gomock.Any(), gomock.Any(), gomock.Any()).Return(db.AddLeafStatus{AlreadyExists: true}, nil))
+	stateman := mocksState.NewMockStateManager(ctrl)
+	stateman.EXPECT().SignedTreeHead().Return(types.SignedTreeHead{}).AnyTimes()
+
+	auditFile := filepath.Join(t.TempDir(), "audit")
+	auditLog, err := audit.Open(auditFile, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := Primary{
+		DbClient:    client,
+		Stateman:    stateman,
+		RateLimiter: rateLimit.NoLimit{},
+		AuditLog:    auditLog,
+	}
+	req := mustLeaf(t, crypto.Hash{}, true)
+	// Only the first, new, submission is recorded.
+	for i := 0; i < 2; i++ {
+		if _, err := node.AddLeaf(context.Background(), req, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record audit.Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("unexpected audit log contents %q: %v", data, err)
+	}
+	keyHash := crypto.HashBytes(req.PublicKey[:])
+	if got, want := record.KeyHash, hex.EncodeToString(keyHash[:]); got != want {
+		t.Errorf("unexpected key hash in audit record, got %s, wanted %s", got, want)
+	}
+}
+
 func TestGetTreeHead(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -241,6 +295,187 @@ func TestGetInclusionProof(t *testing.T) {
 	}
 }
 
+func TestWaitInclusion(t *testing.T) {
+	leafHash := crypto.Hash{1}
+	hexHash := hex.EncodeToString(leafHash[:])
+	cth := func(size uint64, rootHash crypto.Hash) types.CosignedTreeHead {
+		return types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{
+			TreeHead: types.TreeHead{Size: size, RootHash: rootHash}}}
+	}
+	proof := types.InclusionProof{LeafIndex: 3, Path: []crypto.Hash{crypto.Hash{2}}}
+	for _, table := range []struct {
+		description string
+		hash        string
+		cth         types.CosignedTreeHead
+		proofErr    error // from GetInclusionProof for cth
+		rotated     bool  // if a cth of size 6 is published
+		waitErr     error // from WaitCosignedTreeHead
+		wantCode    int
+		wantLines   []string
+	}{
+		{description: "invalid: bad hash", hash: "xx", wantCode: http.StatusBadRequest},
+		{description: "invalid: short hash", hash: "0102", wantCode: http.StatusBadRequest},
+		{description: "valid: included", hash: hexHash, cth: cth(5, crypto.Hash{}),
+			wantCode: http.StatusOK, wantLines: []string{"size=5", "leaf_index=3"}},
+		{description: "valid: tree of size 1", hash: hexHash, cth: cth(1, leafHash),
+			wantCode: http.StatusOK, wantLines: []string{"size=1", "leaf_index=0"}},
+		{description: "valid: included after rotation", hash: hexHash, cth: cth(5, crypto.Hash{}),
+			proofErr: db.ErrNotIncluded, rotated: true, wantCode: http.StatusOK,
+			wantLines: []string{"size=6", "leaf_index=3"}},
+		{description: "valid: timeout", hash: hexHash, cth: cth(5, crypto.Hash{}),
+			proofErr: db.ErrNotIncluded, waitErr: context.DeadlineExceeded, wantCode: http.StatusGatewayTimeout},
+		{description: "invalid: backend failure", hash: hexHash, cth: cth(5, crypto.Hash{}),
+			proofErr: fmt.Errorf("backend failure"), wantCode: http.StatusInternalServerError},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			trillianClient := mocksDB.NewMockClient(ctrl)
+			stateman := mocksState.NewMockStateManager(ctrl)
+			if table.wantCode != http.StatusBadRequest {
+				stateman.EXPECT().CosignedTreeHead().Return(table.cth)
+			}
+			if table.cth.Size > 1 {
+				var p types.InclusionProof
+				if table.proofErr == nil {
+					p = proof
+				}
+				trillianClient.EXPECT().GetInclusionProof(gomock.Any(), &requests.InclusionProof{
+					Size: table.cth.Size, LeafHash: leafHash}).Return(p, table.proofErr)
+			}
+			if table.rotated || table.waitErr != nil {
+				next := cth(6, crypto.Hash{})
+				stateman.EXPECT().WaitCosignedTreeHead(gomock.Any(), table.cth.Size).Return(next, table.waitErr)
+				if table.waitErr == nil {
+					trillianClient.EXPECT().GetInclusionProof(gomock.Any(), &requests.InclusionProof{
+						Size: 6, LeafHash: leafHash}).Return(proof, nil)
+				}
+			}
+			node := Primary{DbClient: trillianClient, Stateman: stateman}
+
+			req := httptest.NewRequest(http.MethodGet, "/wait-inclusion/"+table.hash, nil)
+			req.SetPathValue("hash", table.hash)
+			w := httptest.NewRecorder()
+			node.WaitInclusion(w, req)
+			if got, want := w.Code, table.wantCode; got != want {
+				t.Errorf("in test %q: unexpected status code, got %d, wanted %d", table.description, got, want)
+				return
+			}
+			for _, line := range table.wantLines {
+				if !strings.Contains(w.Body.String(), line+"\n") {
+					t.Errorf("in test %q: line %q missing in response %q", table.description, line, w.Body.String())
+				}
+			}
+		}()
+	}
+}
+
+func TestWaitInclusionSequenced(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	trillianClient := mocksDB.NewMockClient(ctrl)
+	stateman := mocksState.NewMockStateManager(ctrl)
+
+	leafHash := crypto.Hash{1}
+	hexHash := hex.EncodeToString(leafHash[:])
+	cth := func(size uint64) types.CosignedTreeHead {
+		return types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{
+			TreeHead: types.TreeHead{Size: size}}}
+	}
+	proof := types.InclusionProof{LeafIndex: 7, Path: []crypto.Hash{crypto.Hash{2}}}
+
+	// The leaf is found in the backend tree, of size 10, once.
+	// After that, the backend isn't queried until a published tree
+	// head includes it.
+	stateman.EXPECT().CosignedTreeHead().Return(cth(5))
+	trillianClient.EXPECT().GetInclusionProof(gomock.Any(), &requests.InclusionProof{
+		Size: 10, LeafHash: leafHash}).Return(proof, nil)
+	stateman.EXPECT().WaitCosignedTreeHead(gomock.Any(), uint64(7)).Return(cth(8), nil)
+	trillianClient.EXPECT().GetInclusionProof(gomock.Any(), &requests.InclusionProof{
+		Size: 8, LeafHash: leafHash}).Return(proof, nil)
+
+	node := Primary{DbClient: trillianClient, Stateman: stateman, BackendSize: &notify.Size{}}
+	node.BackendSize.Set(10)
+
+	req := httptest.NewRequest(http.MethodGet, "/wait-inclusion/"+hexHash, nil)
+	req.SetPathValue("hash", hexHash)
+	w := httptest.NewRecorder()
+	node.WaitInclusion(w, req)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("unexpected status code, got %d, wanted %d", got, want)
+	}
+	for _, line := range []string{"size=8", "leaf_index=7"} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("line %q missing in response %q", line, w.Body.String())
+		}
+	}
+}
+
+func TestWaitInclusionFirstLeaf(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	trillianClient := mocksDB.NewMockClient(ctrl)
+	stateman := mocksState.NewMockStateManager(ctrl)
+
+	leafHash := crypto.Hash{1}
+	hexHash := hex.EncodeToString(leafHash[:])
+
+	// Empty published tree, while the backend has the first leaf,
+	// for which it can't produce an inclusion proof.
+	stateman.EXPECT().CosignedTreeHead().Return(types.CosignedTreeHead{})
+	trillianClient.EXPECT().GetTreeHead(gomock.Any()).Return(types.TreeHead{Size: 1, RootHash: leafHash}, nil)
+	stateman.EXPECT().WaitCosignedTreeHead(gomock.Any(), uint64(0)).Return(types.CosignedTreeHead{
+		SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 1, RootHash: leafHash}}}, nil)
+
+	node := Primary{DbClient: trillianClient, Stateman: stateman, BackendSize: &notify.Size{}}
+	node.BackendSize.Set(1)
+
+	req := httptest.NewRequest(http.MethodGet, "/wait-inclusion/"+hexHash, nil)
+	req.SetPathValue("hash", hexHash)
+	w := httptest.NewRecorder()
+	node.WaitInclusion(w, req)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("unexpected status code, got %d, wanted %d", got, want)
+	}
+	for _, line := range []string{"size=1", "leaf_index=0"} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("line %q missing in response %q", line, w.Body.String())
+		}
+	}
+}
+
+func TestProofCache(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	trillianClient := mocksDB.NewMockClient(ctrl)
+	stateman := mocksState.NewMockStateManager(ctrl)
+	stateman.EXPECT().CosignedTreeHead().Return(types.CosignedTreeHead{
+		SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 5}}}).AnyTimes()
+
+	consistency := types.ConsistencyProof{Path: []crypto.Hash{crypto.Hash{1}}}
+	inclusion := types.InclusionProof{LeafIndex: 2, Path: []crypto.Hash{crypto.Hash{2}}}
+	// Each backend method is called only once.
+	trillianClient.EXPECT().GetConsistencyProof(gomock.Any(), gomock.Any()).Return(consistency, nil)
+	trillianClient.EXPECT().GetInclusionProof(gomock.Any(), gomock.Any()).Return(inclusion, nil)
+
+	node := Primary{
+		DbClient:          trillianClient,
+		Stateman:          stateman,
+		ConsistencyProofs: cache.NewLRU[requests.ConsistencyProof, types.ConsistencyProof](10),
+		InclusionProofs:   cache.NewLRU[requests.InclusionProof, types.InclusionProof](10),
+	}
+	for i := 0; i < 2; i++ {
+		proof, err := node.GetConsistencyProof(context.Background(), requests.ConsistencyProof{OldSize: 2, NewSize: 5})
+		if err != nil || !pathIsEqual(proof.Path, consistency.Path) {
+			t.Errorf("unexpected consistency proof: %v, err %v", proof, err)
+		}
+		iproof, err := node.GetInclusionProof(context.Background(), requests.InclusionProof{Size: 5, LeafHash: crypto.Hash{3}})
+		if err != nil || iproof.LeafIndex != 2 || !pathIsEqual(iproof.Path, inclusion.Path) {
+			t.Errorf("unexpected inclusion proof: %v, err %v", iproof, err)
+		}
+	}
+}
+
 func TestGetLeaves(t *testing.T) {
 	const testMaxRange = 3
 
@@ -5,11 +5,22 @@ package primary
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strconv"
+	"time"
 
+	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
+const (
+	// How often to poll the backend for a larger tree.
+	backendPollInterval = time.Second
+	// Maximum time a wait-tree-size request is blocked.
+	waitTreeSizeTimeout = 30 * time.Second
+)
+
 func (p Primary) GetLeavesInternal(ctx context.Context, req requests.Leaves) ([]types.Leaf, error) {
 	th, err := p.DbClient.GetTreeHead(ctx)
 	if err != nil {
@@ -17,3 +28,73 @@ func (p Primary) GetLeavesInternal(ctx context.Context, req requests.Leaves) ([]
 	}
 	return p.getLeavesGeneral(ctx, req, th.Size, false)
 }
+
+// GetTreeHeadInternal serves the currently published tree head, in
+// the same format as the public get-tree-head endpoint. It lets a
+// secondary verify that the leaves it replicates are consistent with
+// what the primary publishes.
+func (p Primary) GetTreeHeadInternal(w http.ResponseWriter, _ *http.Request) {
+	log.Debug("handling internal get-tree-head request")
+	cth := p.Stateman.CosignedTreeHead()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := cth.ToASCII(w); err != nil {
+		log.Warning("writing internal get-tree-head response failed: %v", err)
+	}
+}
+
+// WaitTreeSizeInternal is a long-poll endpoint, which blocks until
+// the backend tree is larger than the size in the request, or until
+// timeout. The response is the current size of the backend tree, on
+// the form "size=<decimal>". Lets a secondary start replicating as
+// soon as new leaves are sequenced, rather than polling.
+func (p Primary) WaitTreeSizeInternal(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.ParseUint(r.PathValue("size"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid size: %v", err), http.StatusBadRequest)
+		return
+	}
+	log.Debug("handling wait-tree-size request, size %d", size)
+	ctx, cancel := context.WithTimeout(r.Context(), waitTreeSizeTimeout)
+	defer cancel()
+	// On timeout, respond with the current size; the client is
+	// expected to retry.
+	current, _ := p.BackendSize.Wait(ctx, size)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "size=%d\n", current)
+}
+
+// WatchBackendSize polls the backend for the current tree size, and
+// updates BackendSize, until the context is cancelled.
+func (p Primary) WatchBackendSize(ctx context.Context) {
+	ticker := time.NewTicker(backendPollInterval)
+	defer ticker.Stop()
+	for {
+		th, err := p.DbClient.GetTreeHead(ctx)
+		if err != nil {
+			log.Debug("failed getting backend tree head: %v", err)
+		} else {
+			p.BackendSize.Set(th.Size)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// IndexSequenced calls index with the size of each new published
+// tree head, until the context is cancelled. Failures are logged,
+// and the index is expected to catch up on the next call.
+func (p Primary) IndexSequenced(ctx context.Context, index func(context.Context, uint64) error) {
+	for size := uint64(0); ; {
+		cth, err := p.Stateman.WaitCosignedTreeHead(ctx, size)
+		if err != nil {
+			return
+		}
+		size = cth.Size
+		if err := index(ctx, size); err != nil {
+			log.Warning("failed indexing sequenced leaves: %v", err)
+		}
+	}
+}
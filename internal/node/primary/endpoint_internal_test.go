@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"sigsum.org/log-go/internal/mocks/db"
+	mocksState "sigsum.org/log-go/internal/mocks/state"
+	"sigsum.org/log-go/internal/notify"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/types"
@@ -109,3 +113,93 @@ func TestInternalGetLeaves(t *testing.T) {
 		}()
 	}
 }
+
+func TestInternalGetTreeHead(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	stateman := mocksState.NewMockStateManager(ctrl)
+
+	cth := types.CosignedTreeHead{}
+	cth.Size = 10
+	cth.RootHash = crypto.HashBytes([]byte("root"))
+
+	stateman.EXPECT().CosignedTreeHead().Return(cth)
+
+	node := Primary{
+		Stateman: stateman,
+	}
+	w := httptest.NewRecorder()
+	node.GetTreeHeadInternal(w, httptest.NewRequest(http.MethodGet, "/get-tree-head", nil))
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("unexpected status code, got %d, wanted %d", got, want)
+	}
+	var got types.CosignedTreeHead
+	if err := got.FromASCII(w.Body); err != nil {
+		t.Fatalf("parsing response failed: %v", err)
+	}
+	if got.SignedTreeHead != cth.SignedTreeHead {
+		t.Errorf("unexpected tree head, got %v, expected %v", got, cth)
+	}
+}
+
+func TestWaitTreeSizeInternal(t *testing.T) {
+	node := Primary{BackendSize: &notify.Size{}}
+	node.BackendSize.Set(3)
+
+	for _, table := range []struct {
+		description string
+		size        string
+		wantCode    int
+		wantBody    string
+	}{
+		{"valid: already larger", "2", http.StatusOK, "size=3\n"},
+		{"valid: wakes up when tree grows", "3", http.StatusOK, "size=5\n"},
+		{"invalid: bad size", "x", http.StatusBadRequest, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/wait-tree-size/"+table.size, nil)
+		req.SetPathValue("size", table.size)
+		w := httptest.NewRecorder()
+		if table.wantBody == "size=5\n" {
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				node.BackendSize.Set(5)
+			}()
+		}
+		node.WaitTreeSizeInternal(w, req)
+		if got, want := w.Code, table.wantCode; got != want {
+			t.Errorf("in test %q: unexpected status code, got %d, wanted %d", table.description, got, want)
+			continue
+		}
+		if table.wantBody != "" && w.Body.String() != table.wantBody {
+			t.Errorf("in test %q: unexpected body, got %q, wanted %q", table.description, w.Body.String(), table.wantBody)
+		}
+	}
+}
+
+func TestIndexSequenced(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stateman := mocksState.NewMockStateManager(ctrl)
+	gomock.InOrder(
+		stateman.EXPECT().WaitCosignedTreeHead(gomock.Any(), uint64(0)).Return(cosignedTreeHead(3), nil),
+		stateman.EXPECT().WaitCosignedTreeHead(gomock.Any(), uint64(3)).Return(cosignedTreeHead(5), nil),
+		stateman.EXPECT().WaitCosignedTreeHead(gomock.Any(), uint64(5)).Return(types.CosignedTreeHead{}, context.Canceled),
+	)
+	var sizes []uint64
+	node := Primary{Stateman: stateman}
+	node.IndexSequenced(ctx, func(_ context.Context, size uint64) error {
+		sizes = append(sizes, size)
+		// Failures don't stop indexing.
+		return fmt.Errorf("mock failure")
+	})
+	if got, want := fmt.Sprint(sizes), "[3 5]"; got != want {
+		t.Errorf("unexpected sizes indexed, got %s, want %s", got, want)
+	}
+}
+
+func cosignedTreeHead(size uint64) types.CosignedTreeHead {
+	return types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: size}}}
+}
@@ -1,10 +1,17 @@
 package primary
 
 import (
+	"time"
+
+	"sigsum.org/log-go/internal/audit"
+	"sigsum.org/log-go/internal/cache"
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/notify"
 	"sigsum.org/log-go/internal/rate-limit"
 	"sigsum.org/log-go/internal/state"
+	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/submit-token"
+	"sigsum.org/sigsum-go/pkg/types"
 )
 
 // Primary is an instance of the log's primary node
@@ -14,4 +21,13 @@ type Primary struct {
 	Stateman      state.StateManager // coordinates access to (co)signed tree heads
 	TokenVerifier *token.DnsVerifier // checks if domain name knows a public key
 	RateLimiter   rateLimit.Limiter
+	BackendSize   *notify.Size // latest backend tree size, updated by WatchBackendSize
+	AuditLog      *audit.Log   // if non-nil, records accepted submissions
+	// If non-zero, maximum time a wait-inclusion request is blocked.
+	WaitInclusionTimeout time.Duration
+
+	// Proofs for fixed tree sizes never change. If nil, nothing
+	// is cached.
+	ConsistencyProofs *cache.LRU[requests.ConsistencyProof, types.ConsistencyProof]
+	InclusionProofs   *cache.LRU[requests.InclusionProof, types.InclusionProof]
 }
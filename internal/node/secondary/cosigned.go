@@ -0,0 +1,82 @@
+package secondary
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"git.glasklar.is/sigsum/dependencies/safefile"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Stores a verified tree head from the primary in CosignedFile,
+// unless unchanged since the previous call. Only the latest tree
+// head is kept.
+func (s *Secondary) storeCosigned(cth *types.CosignedTreeHead) error {
+	var buf bytes.Buffer
+	if err := cth.ToASCII(&buf); err != nil {
+		return err
+	}
+	if bytes.Equal(buf.Bytes(), s.cosigned) {
+		return nil
+	}
+	f, err := safefile.Create(s.CosignedFile, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	// Atomically replace old file with new.
+	if err := f.Commit(); err != nil {
+		return err
+	}
+	s.cosigned = buf.Bytes()
+	return nil
+}
+
+// Loads the tree head stored by a previous run, and passes it on to
+// the mirror, after checking it against the local tree. This way, a
+// mirror can serve reads after a restart, even if the primary is
+// unreachable.
+func (s *Secondary) loadCosigned(ctx context.Context) {
+	cth, err := readCosignedFile(s.CosignedFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Warning("ignoring stored primary tree head: %v", err)
+		return
+	}
+	if !cth.SignedTreeHead.Verify(s.PrimaryPub) {
+		log.Warning("ignoring stored primary tree head: invalid signature in file %q", s.CosignedFile)
+		return
+	}
+	replicated, err := s.checkLocalConsistency(ctx, &cth.TreeHead)
+	if err != nil || !replicated {
+		log.Warning("ignoring stored primary tree head, size %d, not verified against local tree: %v", cth.Size, err)
+		return
+	}
+	log.Info("loaded stored primary tree head, size %d", cth.Size)
+	if s.Mirror != nil {
+		s.Mirror.setTreeHead(&cth)
+	}
+}
+
+func readCosignedFile(name string) (types.CosignedTreeHead, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	defer f.Close()
+	var cth types.CosignedTreeHead
+	if err := cth.FromASCII(f); err != nil {
+		return types.CosignedTreeHead{}, fmt.Errorf("invalid file %q: %v", name, err)
+	}
+	return cth, nil
+}
@@ -0,0 +1,65 @@
+package secondary
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestStoreCosigned(t *testing.T) {
+	ctx := context.Background()
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := db.NewMemoryDb()
+	if err := local.AddSequencedLeaves(ctx, []types.Leaf{
+		types.Leaf{Checksum: crypto.Hash{1}},
+		types.Leaf{Checksum: crypto.Hash{2}},
+	}, 0); err != nil {
+		t.Fatal(err)
+	}
+	th, err := local.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sth, err := th.Sign(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cth := types.CosignedTreeHead{SignedTreeHead: sth}
+	largerSth, err := (&types.TreeHead{Size: 3}).Sign(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badSth := sth
+	badSth.Signature[0] ^= 1
+
+	for _, tbl := range []struct {
+		desc   string
+		cth    types.CosignedTreeHead
+		loaded bool
+	}{
+		{"valid", cth, true},
+		{"bad signature", types.CosignedTreeHead{SignedTreeHead: badSth}, false},
+		{"not replicated", types.CosignedTreeHead{SignedTreeHead: largerSth}, false},
+	} {
+		name := filepath.Join(t.TempDir(), "sth.cosigned")
+		s := Secondary{DbClient: local, PrimaryPub: &pub, CosignedFile: name}
+		if err := s.storeCosigned(&tbl.cth); err != nil {
+			t.Fatalf("%s: storing failed: %v", tbl.desc, err)
+		}
+		restarted := Secondary{DbClient: local, PrimaryPub: &pub, CosignedFile: name, Mirror: &Mirror{DbClient: local}}
+		restarted.loadCosigned(ctx)
+		got, err := restarted.Mirror.GetTreeHead(ctx)
+		if loaded := err == nil; loaded != tbl.loaded {
+			t.Errorf("%s: unexpected load status, got %v, wanted %v", tbl.desc, loaded, tbl.loaded)
+		} else if loaded && got.SignedTreeHead != tbl.cth.SignedTreeHead {
+			t.Errorf("%s: unexpected tree head, got %v, wanted %v", tbl.desc, got.SignedTreeHead, tbl.cth.SignedTreeHead)
+		}
+	}
+}
@@ -10,7 +10,7 @@ import (
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
-func (s Secondary) GetSecondaryTreeHead(ctx context.Context) (types.SignedTreeHead, error) {
+func (s *Secondary) GetSecondaryTreeHead(ctx context.Context) (types.SignedTreeHead, error) {
 	log.Debug("handling get-secondary-tree-head request")
 
 	th, err := s.DbClient.GetTreeHead(ctx)
@@ -0,0 +1,133 @@
+package secondary
+
+// This file implements external HTTP handler callbacks for secondary
+// nodes running as read-only mirrors.
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/submit-token"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Mirror serves the log's public read endpoints from the replicated
+// tree. Responses are based on the primary's latest cosigned tree
+// head that has been verified to be consistent with the replicated
+// leaves, so a mirror never serves anything the primary hasn't
+// published.
+type Mirror struct {
+	MaxRange int       // Maximum number of leaves per get-leaves request
+	DbClient db.Client // provides access to the replicated tree
+
+	treeHead atomic.Pointer[types.CosignedTreeHead]
+}
+
+// Records a tree head from the primary, which the caller must have
+// verified against the replicated tree. Tree heads smaller than the
+// current one are ignored.
+func (m *Mirror) setTreeHead(cth *types.CosignedTreeHead) {
+	for {
+		old := m.treeHead.Load()
+		if old != nil && old.Size > cth.Size {
+			return
+		}
+		if m.treeHead.CompareAndSwap(old, cth) {
+			return
+		}
+	}
+}
+
+// TreeSize returns the size of the tree currently served, which is
+// zero until the first tree head from the primary has been verified.
+func (m *Mirror) TreeSize() uint64 {
+	if cth := m.treeHead.Load(); cth != nil {
+		return cth.Size
+	}
+	return 0
+}
+
+func (m *Mirror) currentTreeHead() (*types.CosignedTreeHead, error) {
+	cth := m.treeHead.Load()
+	if cth == nil {
+		return nil, api.ErrNotFound.WithError(fmt.Errorf("no verified tree head from primary yet"))
+	}
+	return cth, nil
+}
+
+func (m *Mirror) GetTreeHead(_ context.Context) (types.CosignedTreeHead, error) {
+	log.Debug("handling mirror get-tree-head request")
+	cth, err := m.currentTreeHead()
+	if err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	return *cth, nil
+}
+
+func (m *Mirror) GetConsistencyProof(ctx context.Context, req requests.ConsistencyProof) (types.ConsistencyProof, error) {
+	log.Debug("handling mirror get-consistency-proof request")
+	cth, err := m.currentTreeHead()
+	if err != nil {
+		return types.ConsistencyProof{}, err
+	}
+	if req.NewSize > cth.Size {
+		return types.ConsistencyProof{}, api.ErrBadRequest.WithError(fmt.Errorf("new_size %d outside of current tree, size %d",
+			req.NewSize, cth.Size))
+	}
+	return m.DbClient.GetConsistencyProof(ctx, &req)
+}
+
+func (m *Mirror) GetInclusionProof(ctx context.Context, req requests.InclusionProof) (types.InclusionProof, error) {
+	log.Debug("handling mirror get-inclusion-proof request")
+	cth, err := m.currentTreeHead()
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	if req.Size > cth.Size {
+		return types.InclusionProof{}, api.ErrBadRequest.WithError(fmt.Errorf("tree_size outside of current tree"))
+	}
+	proof, err := m.DbClient.GetInclusionProof(ctx, &req)
+	if err == db.ErrNotIncluded {
+		err = api.ErrNotFound
+	}
+	return proof, err
+}
+
+func (m *Mirror) GetLeaves(ctx context.Context, req requests.Leaves) ([]types.Leaf, error) {
+	log.Debug("handling mirror get-leaves request")
+	cth, err := m.currentTreeHead()
+	if err != nil {
+		return nil, err
+	}
+	if req.StartIndex >= req.EndIndex {
+		return nil, api.ErrBadRequest.WithError(
+			fmt.Errorf("start_index(%d) must be less than end_index(%d)",
+				req.StartIndex, req.EndIndex))
+	}
+	if req.StartIndex >= cth.Size {
+		return nil, api.ErrBadRequest.WithError(
+			fmt.Errorf("start_index(%d) outside of current tree", req.StartIndex))
+	}
+	if req.EndIndex > cth.Size {
+		return nil, api.ErrBadRequest.WithError(
+			fmt.Errorf("end_index(%d) outside of current tree", req.EndIndex))
+	}
+	if m.MaxRange > 0 && req.EndIndex-req.StartIndex > uint64(m.MaxRange) {
+		req.EndIndex = req.StartIndex + uint64(m.MaxRange)
+	}
+	leaves, err := m.DbClient.GetLeaves(ctx, &req)
+	if err == nil && len(leaves) == 0 {
+		err = fmt.Errorf("backend get leaves returned an empty list")
+	}
+	return leaves, err
+}
+
+// AddLeaf always fails, submissions must go to the primary.
+func (m *Mirror) AddLeaf(_ context.Context, _ requests.Leaf, _ *token.SubmitHeader) (bool, error) {
+	return false, api.ErrNotFound.WithError(fmt.Errorf("read-only mirror, submit to the primary"))
+}
@@ -0,0 +1,80 @@
+package secondary
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func TestMirror(t *testing.T) {
+	ctx := context.Background()
+	local := db.NewMemoryDb()
+	var leaves []types.Leaf
+	for i := 0; i < 10; i++ {
+		leaves = append(leaves, types.Leaf{Checksum: crypto.Hash{byte(i)}})
+	}
+	if err := local.AddSequencedLeaves(ctx, leaves, 0); err != nil {
+		t.Fatal(err)
+	}
+	m := Mirror{MaxRange: 3, DbClient: local}
+
+	if _, err := m.GetTreeHead(ctx); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("unexpected get-tree-head result before first tree head, err: %v", err)
+	}
+	if _, err := m.GetLeaves(ctx, requests.Leaves{StartIndex: 0, EndIndex: 1}); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("unexpected get-leaves result before first tree head, err: %v", err)
+	}
+
+	// Only the first 6 leaves are published.
+	m.setTreeHead(&types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 6}}})
+	m.setTreeHead(&types.CosignedTreeHead{SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 4}}})
+	if got, want := m.TreeSize(), uint64(6); got != want {
+		t.Errorf("unexpected tree size, got %d, want %d", got, want)
+	}
+
+	for _, table := range []struct {
+		start, end uint64
+		want       int // Zero means error
+	}{
+		{0, 2, 2},
+		{0, 6, 3}, // Limited by MaxRange
+		{4, 6, 2},
+		{4, 7, 0}, // Not yet published
+		{6, 7, 0},
+		{2, 2, 0},
+	} {
+		got, err := m.GetLeaves(ctx, requests.Leaves{StartIndex: table.start, EndIndex: table.end})
+		if table.want == 0 {
+			if err == nil {
+				t.Errorf("get-leaves [%d:%d]: expected error, got %d leaves", table.start, table.end, len(got))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("get-leaves [%d:%d] failed: %v", table.start, table.end, err)
+			continue
+		}
+		if len(got) != table.want || got[0] != leaves[table.start] {
+			t.Errorf("get-leaves [%d:%d]: unexpected leaves %v", table.start, table.end, got)
+		}
+	}
+
+	if _, err := m.GetConsistencyProof(ctx, requests.ConsistencyProof{OldSize: 2, NewSize: 6}); err != nil {
+		t.Errorf("get-consistency-proof failed: %v", err)
+	}
+	if _, err := m.GetConsistencyProof(ctx, requests.ConsistencyProof{OldSize: 2, NewSize: 8}); !errors.Is(err, api.ErrBadRequest) {
+		t.Errorf("unexpected get-consistency-proof result outside of published tree, err: %v", err)
+	}
+	if _, err := m.GetInclusionProof(ctx, requests.InclusionProof{Size: 8}); !errors.Is(err, api.ErrBadRequest) {
+		t.Errorf("unexpected get-inclusion-proof result outside of published tree, err: %v", err)
+	}
+	if _, err := m.AddLeaf(ctx, requests.Leaf{}, nil); err == nil {
+		t.Errorf("add-leaf to mirror unexpectedly succeeded")
+	}
+}
@@ -3,6 +3,8 @@ package secondary
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"sigsum.org/log-go/internal/db"
@@ -10,59 +12,312 @@ import (
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
 )
 
 const (
-	leavesBatchSize = 100
+	// Used if no BatchSize is configured, same as the default in
+	// the config.
+	leavesBatchSize = 512
 )
 
+// Returned (wrapped) when the primary's signed tree head is
+// inconsistent with the leaves replicated so far.
+var errPrimaryInconsistent = errors.New("primary tree head inconsistent with local tree")
+
 // Secondary is an instance of a secondary node
 type Secondary struct {
-	Interval time.Duration // Signing frequency
-	DbClient db.Client     // provides access to the backend, usually Trillian
-	Signer   crypto.Signer // provides access to Ed25519 private key
-	Primary  api.Log
+	Interval        time.Duration     // Signing frequency
+	BatchSize       int               // Number of leaves to ask for per get-leaves request
+	ParallelFetches int               // Maximum number of concurrent get-leaves requests
+	DbClient        db.Client         // provides access to the backend, usually Trillian
+	Signer          crypto.Signer     // provides access to Ed25519 private key
+	Primary         api.Log           // provides access to the primary's internal endpoints
+	PrimaryPub      *crypto.PublicKey // if non-nil, used to verify the primary's tree head
+	Waiter          TreeSizeWaiter    // if non-nil, used to be notified about new leaves on the primary
+	Mirror          *Mirror           // if non-nil, gets the primary's tree heads once verified
+	CosignedFile    string            // if non-empty, where the primary's latest verified tree head is stored
+
+	// Batch size reduced to match the primary's max-range, zero
+	// until that is detected. Only accessed by the Run goroutine.
+	maxRange uint64
+	// Lower bound for the size of the primary's tree, learned from
+	// wait-tree-size, tree heads and get-leaves responses.
+	primarySize atomic.Uint64
+	// Contents of CosignedFile, to write it only on changes. Only
+	// accessed by the Run goroutine.
+	cosigned []byte
 }
 
-func (s Secondary) Run(ctx context.Context) {
+// Pending or completed get-leaves request.
+type fetchResult struct {
+	req requests.Leaves
+	// Set if the primary was known to have all requested leaves
+	// when the request was made.
+	full   bool
+	leaves []types.Leaf
+	err    error
+}
+
+func (s *Secondary) Run(ctx context.Context) {
 	ticker := time.NewTicker(s.Interval)
 	defer ticker.Stop()
 
+	// Stays nil, i.e., never ready, if there's no Waiter.
+	var wakeup chan struct{}
+	if s.Waiter != nil {
+		wakeup = make(chan struct{}, 1)
+		go s.waitForLeaves(ctx, wakeup)
+	}
+
+	if s.CosignedFile != "" && s.PrimaryPub != nil {
+		s.loadCosigned(ctx)
+	}
+
+	// Start catching up immediately, rather than after the first
+	// interval.
 	for {
+		s.fetchLeavesFromPrimary(ctx)
+		if s.PrimaryPub != nil {
+			if err := s.verifyPrimaryTreeHead(ctx); err != nil {
+				if errors.Is(err, errPrimaryInconsistent) {
+					log.Error("stopping replication: %v", err)
+					<-ctx.Done()
+					return
+				}
+				log.Warning("unable to verify primary tree head: %v", err)
+			}
+		}
 		select {
 		case <-ticker.C:
-			s.fetchLeavesFromPrimary(ctx)
+		case <-wakeup:
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (s Secondary) fetchLeavesFromPrimary(ctx context.Context) {
+// Long-polls the primary, and signals on wakeup whenever the
+// primary's tree has grown. Returns when the context is cancelled, or
+// if the primary doesn't support long-polling, in which case
+// replication falls back to polling every Interval.
+func (s *Secondary) waitForLeaves(ctx context.Context, wakeup chan<- struct{}) {
+	var size uint64
 	for {
-		curTH, err := s.DbClient.GetTreeHead(ctx)
-		if err != nil {
-			log.Warning("unable to get tree head from trillian: %v", err)
+		newSize, err := s.Waiter.WaitTreeSize(ctx, size)
+		if ctx.Err() != nil {
 			return
 		}
-		req := requests.Leaves{
-			StartIndex: curTH.Size,
-			EndIndex:   curTH.Size + leavesBatchSize,
-		}
-		leaves, err := s.Primary.GetLeaves(ctx, req)
 		if err != nil {
-			if errors.Is(api.ErrNotFound, err) {
-				// Normal way to exit, so don't log at warning level.
-				log.Debug("error fetching leaves [%d:%d] from primary: %v", req.StartIndex, req.EndIndex, err)
-			} else {
-				log.Warning("error fetching leaves [%d:%d] from primary: %v", req.StartIndex, req.EndIndex, err)
+			if errors.Is(err, errWaitNotSupported) {
+				log.Info("primary doesn't support long-polling, replicating every %v", s.Interval)
+				return
 			}
+			log.Warning("waiting for primary tree size failed: %v", err)
+			select {
+			case <-time.After(s.Interval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if newSize > size {
+			size = newSize
+			s.updatePrimarySize(size)
+			select {
+			case wakeup <- struct{}{}:
+			default:
+				// A wakeup is already pending.
+			}
+		}
+	}
+}
+
+// Raises the known lower bound for the primary's tree size.
+func (s *Secondary) updatePrimarySize(size uint64) {
+	for {
+		old := s.primarySize.Load()
+		if old >= size || s.primarySize.CompareAndSwap(old, size) {
 			return
 		}
-		log.Debug("got %d leaves from primary when asking for [%d:%d]", len(leaves), req.StartIndex, req.EndIndex)
-		if err := s.DbClient.AddSequencedLeaves(ctx, leaves, int64(req.StartIndex)); err != nil {
-			log.Error("AddSequencedLeaves: %v", err)
+	}
+}
+
+func (s *Secondary) batchSize() uint64 {
+	size := uint64(s.BatchSize)
+	if size == 0 {
+		size = leavesBatchSize
+	}
+	if s.maxRange > 0 && s.maxRange < size {
+		return s.maxRange
+	}
+	return size
+}
+
+func (s *Secondary) parallelFetches() int {
+	if s.ParallelFetches < 1 {
+		return 1
+	}
+	return s.ParallelFetches
+}
+
+// Fetches leaves from the primary until caught up, i.e., until the
+// primary has no more leaves or an error occurs.
+func (s *Secondary) fetchLeavesFromPrimary(ctx context.Context) {
+	curTH, err := s.DbClient.GetTreeHead(ctx)
+	if err != nil {
+		log.Warning("unable to get tree head from trillian: %v", err)
+		return
+	}
+	start := curTH.Size
+	for {
+		var more bool
+		start, more = s.fetchBatches(ctx, start)
+		if !more {
 			return
 		}
 	}
 }
+
+// Issues up to ParallelFetches concurrent get-leaves requests, for
+// consecutive ranges starting at index start, and adds the resulting
+// leaves to the local tree strictly in order. Returns the index
+// following the last added leaf, and true if fetching should be
+// restarted from that index.
+func (s *Secondary) fetchBatches(ctx context.Context, start uint64) (uint64, bool) {
+	// Cancels any outstanding requests on return.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batchSize := s.batchSize()
+	next := start
+	var pending []chan fetchResult
+	fetch := func() {
+		req := requests.Leaves{
+			StartIndex: next,
+			EndIndex:   next + batchSize,
+		}
+		next = req.EndIndex
+		full := req.EndIndex <= s.primarySize.Load()
+		ch := make(chan fetchResult, 1)
+		go func() {
+			leaves, err := s.Primary.GetLeaves(ctx, req)
+			ch <- fetchResult{req: req, full: full, leaves: leaves, err: err}
+		}()
+		pending = append(pending, ch)
+	}
+	for i := 0; i < s.parallelFetches(); i++ {
+		fetch()
+	}
+	for {
+		r := <-pending[0]
+		pending = pending[1:]
+		if r.err != nil {
+			logFetchError(&r)
+			return start, false
+		}
+		count := uint64(len(r.leaves))
+		log.Debug("got %d leaves from primary when asking for [%d:%d]", count, r.req.StartIndex, r.req.EndIndex)
+		if count == 0 || count > r.req.EndIndex-r.req.StartIndex {
+			log.Warning("unexpected number of leaves from primary: got %d when asking for [%d:%d]",
+				count, r.req.StartIndex, r.req.EndIndex)
+			return start, false
+		}
+		if err := s.DbClient.AddSequencedLeaves(ctx, r.leaves, int64(r.req.StartIndex)); err != nil {
+			log.Error("AddSequencedLeaves: %v", err)
+			return start, false
+		}
+		start += count
+		s.updatePrimarySize(start)
+		if count < batchSize {
+			// Either we're at the end of the primary's
+			// tree, or the primary's max-range is smaller
+			// than our batch size. In both cases, ranges
+			// already requested are no longer contiguous
+			// with the leaves we have. Only if the primary
+			// was known to have all the requested leaves,
+			// it must be max-range.
+			if r.full {
+				log.Info("reducing get-leaves batch size from %d to %d, to match primary's max-range", batchSize, count)
+				s.maxRange = count
+				return start, true
+			}
+			if len(pending) == 0 {
+				return start, true
+			}
+			// Wait for the next response, to learn if
+			// the primary has more leaves. If so, the
+			// restarted request tells if it's max-range.
+			r := <-pending[0]
+			if r.err != nil {
+				logFetchError(&r)
+				return start, false
+			}
+			s.updatePrimarySize(r.req.StartIndex + uint64(len(r.leaves)))
+			return start, true
+		}
+		fetch()
+	}
+}
+
+func logFetchError(r *fetchResult) {
+	if errors.Is(api.ErrNotFound, r.err) {
+		// Normal way to exit, so don't log at warning level.
+		log.Debug("error fetching leaves [%d:%d] from primary: %v", r.req.StartIndex, r.req.EndIndex, r.err)
+	} else {
+		log.Warning("error fetching leaves [%d:%d] from primary: %v", r.req.StartIndex, r.req.EndIndex, r.err)
+	}
+}
+
+// Checks that the primary's latest tree head is consistent with the
+// local tree. A tree head larger than the local tree can't be checked
+// yet, and is ignored until the corresponding leaves are replicated.
+func (s *Secondary) verifyPrimaryTreeHead(ctx context.Context) error {
+	cth, err := s.Primary.GetTreeHead(ctx)
+	if err != nil {
+		return fmt.Errorf("failed fetching tree head from primary: %w", err)
+	}
+	if !cth.SignedTreeHead.Verify(s.PrimaryPub) {
+		return fmt.Errorf("invalid signature on primary's tree head")
+	}
+	s.updatePrimarySize(cth.Size)
+	if replicated, err := s.checkLocalConsistency(ctx, &cth.TreeHead); err != nil || !replicated {
+		return err
+	}
+	if s.Mirror != nil {
+		s.Mirror.setTreeHead(&cth)
+	}
+	if s.CosignedFile != "" {
+		if err := s.storeCosigned(&cth); err != nil {
+			log.Warning("storing primary tree head failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// Checks that a tree head from the primary is consistent with the
+// local tree. Returns false, and no error, if the tree head is
+// larger than the local tree, since it can't be checked yet.
+func (s *Secondary) checkLocalConsistency(ctx context.Context, th *types.TreeHead) (bool, error) {
+	localTH, err := s.DbClient.GetTreeHead(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed getting local tree head: %w", err)
+	}
+	if th.Size > localTH.Size {
+		log.Debug("primary tree head not yet replicated: %d > %d", th.Size, localTH.Size)
+		return false, nil
+	}
+	proof, err := s.DbClient.GetConsistencyProof(ctx, &requests.ConsistencyProof{
+		OldSize: th.Size,
+		NewSize: localTH.Size,
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to get local consistency proof from %d to %d: %w", th.Size, localTH.Size, err)
+	}
+	if err := proof.Verify(th, &localTH); err != nil {
+		return false, fmt.Errorf("%w: primary size %d, local size %d: %v",
+			errPrimaryInconsistent, th.Size, localTH.Size, err)
+	}
+	log.Debug("primary tree head size %d consistent with local size %d", th.Size, localTH.Size)
+	return true, nil
+}
@@ -2,12 +2,19 @@ package secondary
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"sync/atomic"
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	"sigsum.org/log-go/internal/db"
 	mocksDB "sigsum.org/log-go/internal/mocks/db"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/mocks"
+	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
@@ -59,11 +66,6 @@ func TestFetchLeavesFromPrimary(t *testing.T) {
 
 			trillianClient := mocksDB.NewMockClient(ctrl)
 			trillianClient.EXPECT().GetTreeHead(gomock.Any()).Return(tbl.trillianTHRet, tbl.trillianTHErr)
-			if tbl.trillianTHErr == nil && tbl.primaryGetLeavesErr == nil && tbl.trillianAddLeavesErr == nil {
-				updatedSize := tbl.trillianTHRet.Size + uint64(len(tbl.primaryGetLeavesRet))
-				trillianClient.EXPECT().GetTreeHead(gomock.Any()).Return(
-					types.TreeHead{Size: updatedSize}, tbl.trillianTHErr)
-			}
 
 			if tbl.primaryGetLeavesErr != nil || tbl.primaryGetLeavesRet != nil {
 				primaryClient.EXPECT().GetLeaves(gomock.Any(), gomock.Any()).Return(tbl.primaryGetLeavesRet, tbl.primaryGetLeavesErr)
@@ -90,3 +92,159 @@ func TestFetchLeavesFromPrimary(t *testing.T) {
 		}()
 	}
 }
+
+func TestFetchLeavesParallel(t *testing.T) {
+	var leaves []types.Leaf
+	for i := 0; i < 50; i++ {
+		leaves = append(leaves, types.Leaf{Checksum: crypto.Hash{byte(i)}})
+	}
+	for _, tbl := range []struct {
+		desc        string
+		localSize   int
+		batchSize   int
+		parallel    int
+		maxRange    uint64
+		growing     bool // if set, the primary's tree grows by 3 leaves per request
+		wantMaxSize uint64
+	}{
+		{desc: "sequential", batchSize: 7, parallel: 1, maxRange: 10},
+		{desc: "parallel", batchSize: 7, parallel: 4, maxRange: 10},
+		{desc: "parallel, partially replicated", localSize: 20, batchSize: 7, parallel: 4, maxRange: 10},
+		{desc: "parallel, limited by max-range", batchSize: 20, parallel: 3, maxRange: 8, wantMaxSize: 8},
+		{desc: "parallel, large batch", batchSize: 100, parallel: 3, maxRange: 100},
+		{desc: "parallel, growing primary", batchSize: 7, parallel: 4, maxRange: 10, growing: true},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			ctx := context.Background()
+			local := db.NewMemoryDb()
+			if err := local.AddSequencedLeaves(ctx, leaves[:tbl.localSize], 0); err != nil {
+				t.Fatal(err)
+			}
+			var calls atomic.Uint64
+			primaryClient := mocks.NewMockLog(ctrl)
+			primaryClient.EXPECT().GetLeaves(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, req requests.Leaves) ([]types.Leaf, error) {
+					size := uint64(len(leaves))
+					if tbl.growing {
+						size = min(size, 3*calls.Add(1))
+					}
+					if req.StartIndex >= size {
+						return nil, api.ErrNotFound
+					}
+					end := req.EndIndex
+					if end > size {
+						end = size
+					}
+					if end-req.StartIndex > tbl.maxRange {
+						end = req.StartIndex + tbl.maxRange
+					}
+					return leaves[req.StartIndex:end], nil
+				}).AnyTimes()
+
+			node := Secondary{
+				BatchSize:       tbl.batchSize,
+				ParallelFetches: tbl.parallel,
+				Primary:         primaryClient,
+				DbClient:        local,
+			}
+			// A growing primary may need several rounds.
+			for i := 0; i < 30; i++ {
+				node.fetchLeavesFromPrimary(ctx)
+			}
+
+			got, err := local.GetLeaves(ctx, &requests.Leaves{StartIndex: 0, EndIndex: uint64(len(leaves))})
+			if err != nil {
+				t.Fatalf("%s: local GetLeaves failed: %v", tbl.desc, err)
+			}
+			if !reflect.DeepEqual(got, leaves) {
+				t.Errorf("%s: replicated leaves differ from primary's leaves", tbl.desc)
+			}
+			if got, want := node.maxRange, tbl.wantMaxSize; got != want {
+				t.Errorf("%s: unexpected max range, got %d, wanted %d", tbl.desc, got, want)
+			}
+		}()
+	}
+}
+
+func TestVerifyPrimaryTreeHead(t *testing.T) {
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := []types.Leaf{
+		types.Leaf{Checksum: crypto.Hash{1}},
+		types.Leaf{Checksum: crypto.Hash{2}},
+		types.Leaf{Checksum: crypto.Hash{3}},
+	}
+	forkedLeaves := []types.Leaf{
+		types.Leaf{Checksum: crypto.Hash{1}},
+		types.Leaf{Checksum: crypto.Hash{4}},
+	}
+	for _, tbl := range []struct {
+		desc         string
+		primary      []types.Leaf
+		badSignature bool
+		wantErr      bool
+		inconsistent bool
+		mirrored     bool
+	}{
+		{desc: "empty", primary: leaves[:0], mirrored: true},
+		{desc: "consistent prefix", primary: leaves[:2], mirrored: true},
+		{desc: "consistent", primary: leaves, mirrored: true},
+		{desc: "not yet replicated", primary: append(leaves, types.Leaf{Checksum: crypto.Hash{5}})},
+		{desc: "bad signature", primary: leaves[:2], badSignature: true, wantErr: true},
+		{desc: "fork", primary: forkedLeaves, wantErr: true, inconsistent: true},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			ctx := context.Background()
+			local := db.NewMemoryDb()
+			if err := local.AddSequencedLeaves(ctx, leaves, 0); err != nil {
+				t.Fatal(err)
+			}
+			primaryDb := db.NewMemoryDb()
+			if err := primaryDb.AddSequencedLeaves(ctx, tbl.primary, 0); err != nil {
+				t.Fatal(err)
+			}
+			th, err := primaryDb.GetTreeHead(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sth, err := th.Sign(signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tbl.badSignature {
+				sth.Signature[0] ^= 1
+			}
+			primaryClient := mocks.NewMockLog(ctrl)
+			primaryClient.EXPECT().GetTreeHead(gomock.Any()).Return(
+				types.CosignedTreeHead{SignedTreeHead: sth}, nil)
+
+			node := Secondary{
+				Primary:    primaryClient,
+				PrimaryPub: &pub,
+				DbClient:   local,
+				Mirror:     &Mirror{DbClient: local},
+			}
+			err = node.verifyPrimaryTreeHead(ctx)
+			if got, want := err != nil, tbl.wantErr; got != want {
+				t.Errorf("%s: unexpected result, got error %v, wanted error %v", tbl.desc, err, want)
+			}
+			if got, want := errors.Is(err, errPrimaryInconsistent), tbl.inconsistent; got != want {
+				t.Errorf("%s: unexpected inconsistency status, got %v, wanted %v", tbl.desc, got, want)
+			}
+			cth, err := node.Mirror.GetTreeHead(ctx)
+			if got, want := err == nil, tbl.mirrored; got != want {
+				t.Errorf("%s: unexpected mirror status, got %v, wanted %v", tbl.desc, got, want)
+			} else if err == nil && cth.SignedTreeHead != sth {
+				t.Errorf("%s: unexpected mirror tree head, got %v, wanted %v", tbl.desc, cth.SignedTreeHead, sth)
+			}
+		}()
+	}
+}
@@ -0,0 +1,70 @@
+package secondary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/ascii"
+)
+
+// Must exceed the time the primary blocks a wait-tree-size request.
+const waitTreeSizeTimeout = 60 * time.Second
+
+// Returned when the primary doesn't implement the wait-tree-size
+// endpoint, e.g., because it runs an older version.
+var errWaitNotSupported = errors.New("wait-tree-size not supported by primary")
+
+// TreeSizeWaiter blocks until the primary's tree is larger than size,
+// or until some timeout, and returns the primary's current size.
+type TreeSizeWaiter interface {
+	WaitTreeSize(ctx context.Context, size uint64) (uint64, error)
+}
+
+type waitClient struct {
+	url    string
+	client *http.Client
+}
+
+// NewTreeSizeWaiter returns a TreeSizeWaiter using the primary's
+// internal wait-tree-size endpoint, under the given url. If
+// httpClient is non-nil, its transport is used for the requests.
+func NewTreeSizeWaiter(url string, httpClient *http.Client) TreeSizeWaiter {
+	client := &http.Client{Timeout: waitTreeSizeTimeout}
+	if httpClient != nil {
+		client.Transport = httpClient.Transport
+	}
+	return &waitClient{
+		url:    strings.TrimSuffix(url, "/"),
+		client: client,
+	}
+}
+
+func (c *waitClient) WaitTreeSize(ctx context.Context, size uint64) (uint64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s/wait-tree-size/%d", c.url, size), nil)
+	if err != nil {
+		return 0, err
+	}
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+	switch rsp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return 0, errWaitNotSupported
+	default:
+		return 0, fmt.Errorf("unexpected status from primary: %s", rsp.Status)
+	}
+	p := ascii.NewParser(rsp.Body)
+	newSize, err := p.GetInt("size")
+	if err != nil {
+		return 0, fmt.Errorf("invalid wait-tree-size response: %w", err)
+	}
+	return newSize, nil
+}
@@ -0,0 +1,35 @@
+package secondary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWaitTreeSize(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /testonly/wait-tree-size/{size}", func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.PathValue("size"), "5"; got != want {
+			t.Errorf("unexpected size in request, got %q, wanted %q", got, want)
+		}
+		fmt.Fprintf(w, "size=7\n")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	size, err := NewTreeSizeWaiter(server.URL+"/testonly/", nil).WaitTreeSize(context.Background(), 5)
+	if err != nil {
+		t.Fatalf("WaitTreeSize failed: %v", err)
+	}
+	if size != 7 {
+		t.Errorf("unexpected size, got %d, wanted 7", size)
+	}
+
+	_, err = NewTreeSizeWaiter(server.URL, nil).WaitTreeSize(context.Background(), 5)
+	if !errors.Is(err, errWaitNotSupported) {
+		t.Errorf("unexpected error for missing endpoint, got %v, wanted %v", err, errWaitNotSupported)
+	}
+}
@@ -0,0 +1,63 @@
+// Package notify lets goroutines wait for a monotonically increasing
+// size, e.g., the size of a tree, to grow.
+package notify
+
+import (
+	"context"
+	"sync"
+)
+
+// Size is a size that can only grow. The zero value is a size of
+// zero, ready to use.
+type Size struct {
+	mu   sync.Mutex
+	size uint64
+	// Closed, and replaced, each time the size grows.
+	grown chan struct{}
+}
+
+func (s *Size) channel() chan struct{} {
+	if s.grown == nil {
+		s.grown = make(chan struct{})
+	}
+	return s.grown
+}
+
+// Get returns the current size.
+func (s *Size) Get() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Set updates the size, and wakes up any waiters. A size smaller
+// than the current one is ignored.
+func (s *Size) Set(size uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size <= s.size {
+		return
+	}
+	s.size = size
+	close(s.channel())
+	s.grown = nil
+}
+
+// Wait blocks until the size is larger than size, or the context is
+// done. Returns the current size, together with the context's error
+// if the size didn't grow in time.
+func (s *Size) Wait(ctx context.Context, size uint64) (uint64, error) {
+	for {
+		s.mu.Lock()
+		current, grown := s.size, s.channel()
+		s.mu.Unlock()
+		if current > size {
+			return current, nil
+		}
+		select {
+		case <-grown:
+		case <-ctx.Done():
+			return current, ctx.Err()
+		}
+	}
+}
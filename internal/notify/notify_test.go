@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitAlreadyLarger(t *testing.T) {
+	var s Size
+	s.Set(5)
+	for _, size := range []uint64{0, 4} {
+		got, err := s.Wait(context.Background(), size)
+		if err != nil {
+			t.Errorf("Wait(%d) failed: %v", size, err)
+		} else if got != 5 {
+			t.Errorf("Wait(%d) returned size %d, wanted 5", size, got)
+		}
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	var s Size
+	s.Set(5)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	got, err := s.Wait(ctx, 5)
+	if err == nil {
+		t.Errorf("Wait(5) succeeded, despite no change in size")
+	}
+	if got != 5 {
+		t.Errorf("Wait(5) returned size %d, wanted 5", got)
+	}
+}
+
+func TestSetIgnoresSmaller(t *testing.T) {
+	var s Size
+	s.Set(5)
+	s.Set(3)
+	if got := s.Get(); got != 5 {
+		t.Errorf("got size %d, wanted 5", got)
+	}
+}
+
+func TestWaitWakeup(t *testing.T) {
+	var s Size
+	done := make(chan uint64)
+	for i := 0; i < 3; i++ {
+		go func() {
+			got, err := s.Wait(context.Background(), 2)
+			if err != nil {
+				t.Errorf("Wait failed: %v", err)
+			}
+			done <- got
+		}()
+	}
+	// Doesn't satisfy the waiters.
+	s.Set(1)
+	s.Set(2)
+	s.Set(7)
+	for i := 0; i < 3; i++ {
+		if got := <-done; got != 7 {
+			t.Errorf("Wait returned size %d, wanted 7", got)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package rateLimit
 
 import (
+	"encoding/hex"
 	"io"
 	"os"
 	"strings"
@@ -22,14 +23,17 @@ const testDomain = "test.sigsum.org"
 type Limiter interface {
 	// Checks if access count is < limit. If so increment count
 	// and returns a function that can be called to undo the increment, in case no
-	// resources were consumed. Otherwise, returns nil.
-	AccessAllowed(domain *string, keyHash *crypto.Hash) func()
+	// resources were consumed. Otherwise, returns nil. Also
+	// returns the name of the bucket that the request is counted
+	// against, e.g., "key:<hex key hash>", "domain:<allowed domain>"
+	// or "public:<registered domain>", or empty if none applies.
+	AccessAllowed(domain *string, keyHash *crypto.Hash) (func(), string)
 }
 
 type NoLimit struct{}
 
-func (l NoLimit) AccessAllowed(_ *string, _ *crypto.Hash) func() {
-	return func() {}
+func (l NoLimit) AccessAllowed(_ *string, _ *crypto.Hash) (func(), string) {
+	return func() {}, ""
 }
 
 var schedulePeriod = 24 * time.Hour
@@ -74,22 +78,23 @@ type limiter struct {
 }
 
 // Checks if domain or a suffix of domain is allowed. Second return
-// value is true if domain was matched by the allow list.
-func (l *limiter) domainAllowed(domain string) (func(), bool) {
+// value is the matching entry of the allow list, or empty if none
+// matched.
+func (l *limiter) domainAllowed(domain string) (func(), string) {
 	s := domain
 	for {
 		if limit, ok := l.allowedDomains[s]; ok {
-			return l.domainCounts.AccessAllowed(s, limit), true
+			return l.domainCounts.AccessAllowed(s, limit), s
 		}
 		dot := strings.Index(s, ".")
 		if dot < 0 {
-			return nil, false
+			return nil, ""
 		}
 		s = s[dot+1:]
 	}
 }
 
-func (l *limiter) AccessAllowed(submitDomain *string, keyHash *crypto.Hash) func() {
+func (l *limiter) AccessAllowed(submitDomain *string, keyHash *crypto.Hash) (func(), string) {
 	if l.resetSchedule.IsTime() {
 		l.keyCounts.Reset()
 		l.domainCounts.Reset()
@@ -99,29 +104,29 @@ func (l *limiter) AccessAllowed(submitDomain *string, keyHash *crypto.Hash) func
 	// TODO: Avoid conversion to string.
 	keyHashString := string(keyHash[:])
 	if limit, ok := l.allowedKeys[keyHashString]; ok {
-		return l.keyCounts.AccessAllowed(keyHashString, limit)
+		return l.keyCounts.AccessAllowed(keyHashString, limit), "key:" + hex.EncodeToString(keyHash[:])
 	}
 	if submitDomain == nil {
 		// Skip all domain-based checks.
-		return nil
+		return nil, ""
 	}
 	domain, err := token.NormalizeDomainName(*submitDomain)
 	if err != nil {
-		return nil
+		return nil, ""
 	}
-	if relax, ok := l.domainAllowed(domain); ok {
-		return relax
+	if relax, allowed := l.domainAllowed(domain); allowed != "" {
+		return relax, "domain:" + allowed
 	}
 	if l.allowPublic <= 0 {
-		return nil
+		return nil, ""
 	}
 
 	domain, err = l.domainDb.GetRegisteredDomain(domain)
 	if err != nil {
 		// Reject unknown domains.
-		return nil
+		return nil, ""
 	}
-	return l.publicCounts.AccessAllowed(domain, l.allowPublic)
+	return l.publicCounts.AccessAllowed(domain, l.allowPublic), "public:" + domain
 }
 
 func newLimiter(configFile io.Reader, allowTestDomain bool, clock clock) (Limiter, error) {
@@ -47,7 +47,7 @@ func repeatedAccess(t *testing.T, config string, count int, requests []request)
 	}
 	for i := 0; i < count; i++ {
 		r := &requests[i%len(requests)]
-		if limiter.AccessAllowed(r.domain, r.keyHash) == nil {
+		if relax, _ := limiter.AccessAllowed(r.domain, r.keyHash); relax == nil {
 			return i
 		}
 		clock.Advance(r.delay)
@@ -168,3 +168,32 @@ func TestPublicLimit(t *testing.T) {
 	}
 
 }
+
+func TestBucket(t *testing.T) {
+	A := func(s string) *string { return &s }
+	key1 := crypto.Hash{1}
+	key2 := crypto.Hash{2}
+	config := fmt.Sprintf("key %x 10\n", key1) +
+		"domain foo.example.com 10\n" +
+		"public test_suffix_list.dat 10\n"
+	limiter, err := newTestLimiter(config, &fakeClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []struct {
+		domain  *string
+		keyHash *crypto.Hash
+		want    string
+	}{
+		{nil, &key1, fmt.Sprintf("key:%x", key1)},
+		{A("foo.example.com"), &key1, fmt.Sprintf("key:%x", key1)},
+		{A("www.foo.Example.com"), &key2, "domain:foo.example.com"},
+		{A("www.foo.example.org"), &key2, "public:example.org"},
+		{nil, &key2, ""},
+	} {
+		if _, got := limiter.AccessAllowed(table.domain, table.keyHash); got != table.want {
+			t.Errorf("unexpected bucket for domain %v, key %x: got %q, wanted %q",
+				table.domain, *table.keyHash, got, table.want)
+		}
+	}
+}
@@ -2,9 +2,11 @@ package state
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"sigsum.org/log-go/internal/tracing"
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/log"
@@ -38,11 +40,15 @@ func (r ReplicationState) getPrimaryTreeHead(ctx context.Context, minSize uint64
 	return primaryTreeHead, nil
 }
 
+// Wrapped by errors due to failing to get any response from the
+// secondary.
+var errSecondaryUnreachable = errors.New("secondary unreachable")
+
 // Return the latest secondary tree head with size at least minSize.
 func (r ReplicationState) getSecondaryTreeHead(ctx context.Context, minSize uint64, maxSize uint64) (types.TreeHead, error) {
 	sth, err := r.secondary.GetSecondaryTreeHead(ctx)
 	if err != nil {
-		return types.TreeHead{}, fmt.Errorf("failed fetching tree head from secondary: %w", err)
+		return types.TreeHead{}, fmt.Errorf("%w: %w", errSecondaryUnreachable, err)
 	}
 	if !sth.Verify(&r.secondaryPub) {
 		return types.TreeHead{}, fmt.Errorf("invalid signature on secondary's tree head")
@@ -74,27 +80,42 @@ func (r ReplicationState) checkConsistency(ctx context.Context, old *types.TreeH
 
 // Identifies the latest tree head replicated by the secondary, and
 // with size >= minSize, or fails if priamry or secondary is in a bad
-// or too old state.
-func (r ReplicationState) ReplicatedTreeHead(ctx context.Context, minSize uint64) (types.TreeHead, error) {
+// or too old state. The returned bool is true if the secondary
+// responded, regardless of whether or not its tree head was usable.
+func (r ReplicationState) ReplicatedTreeHead(ctx context.Context, minSize uint64) (types.TreeHead, bool, error) {
+	ctx, span := tracing.Start(ctx, "state.ReplicatedTreeHead", "min_size", minSize)
+	defer span.End()
+	th, secondaryReached, err := r.replicatedTreeHead(ctx, minSize)
+	span.SetError(err)
+	span.SetAttributes("size", th.Size)
+	return th, secondaryReached, err
+}
+
+func (r ReplicationState) replicatedTreeHead(ctx context.Context, minSize uint64) (types.TreeHead, bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
 	primaryTreeHead, err := r.getPrimaryTreeHead(ctx, minSize)
 	if err != nil {
-		return types.TreeHead{}, err
+		return types.TreeHead{}, false, err
 	}
-	if primaryTreeHead.Size == minSize || r.secondary == nil {
-		return primaryTreeHead, nil
+	if r.secondary == nil {
+		return primaryTreeHead, false, nil
 	}
-
+	// The secondary is contacted also when the tree hasn't grown,
+	// so that recovery of the secondary is noticed.
 	secTreeHead, err := r.getSecondaryTreeHead(ctx, minSize, primaryTreeHead.Size)
+	secondaryReached := !errors.Is(err, errSecondaryUnreachable)
 	if err != nil {
-		return types.TreeHead{}, fmt.Errorf("failed fetching tree head from secondary: %w", err)
+		return types.TreeHead{}, secondaryReached, fmt.Errorf("failed fetching tree head from secondary: %w", err)
+	}
+	if primaryTreeHead.Size == minSize {
+		return primaryTreeHead, true, nil
 	}
 
 	if err := r.checkConsistency(ctx, &secTreeHead, &primaryTreeHead); err != nil {
-		return types.TreeHead{}, err
+		return types.TreeHead{}, true, err
 	}
 	log.Debug("using latest tree head from secondary: size %d", secTreeHead.Size)
-	return secTreeHead, nil
+	return secTreeHead, true, nil
 }
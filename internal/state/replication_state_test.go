@@ -2,7 +2,9 @@ package state
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"sigsum.org/log-go/internal/mocks/db"
@@ -145,3 +147,47 @@ func TestCheckConsistency(t *testing.T) {
 		}
 	}
 }
+
+func TestReplicatedTreeHeadSecondaryReached(t *testing.T) {
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	th := types.TreeHead{Size: 5}
+	sth, err := th.Sign(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []struct {
+		desc         string
+		secondaryErr error
+		wantReached  bool
+		wantErr      bool
+	}{
+		{desc: "secondary up", wantReached: true},
+		{desc: "secondary down", secondaryErr: fmt.Errorf("connection refused"), wantErr: true},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			primary := db.NewMockClient(ctrl)
+			primary.EXPECT().GetTreeHead(gomock.Any()).Return(th, nil)
+			secondary := mocks.NewMockSecondary(ctrl)
+			// Contacted even though the tree hasn't grown.
+			secondary.EXPECT().GetSecondaryTreeHead(gomock.Any()).Return(sth, table.secondaryErr)
+
+			state := ReplicationState{timeout: time.Second, primary: primary, secondary: secondary, secondaryPub: pub}
+			got, reached, err := state.ReplicatedTreeHead(context.Background(), 5)
+			if (err != nil) != table.wantErr {
+				t.Errorf("%s: unexpected result from ReplicatedTreeHead: %v", table.desc, err)
+			}
+			if reached != table.wantReached {
+				t.Errorf("%s: unexpected secondary reached: %v", table.desc, reached)
+			}
+			if err == nil && got != th {
+				t.Errorf("%s: unexpected tree head %v, expected %v", table.desc, got, th)
+			}
+		}()
+	}
+}
@@ -2,28 +2,58 @@ package state
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"sync"
 	"time"
 
+	"sigsum.org/log-go/internal/logging"
+	"sigsum.org/log-go/internal/notify"
+	"sigsum.org/log-go/internal/tracing"
 	"sigsum.org/log-go/internal/witness"
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/policy"
+	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
+// Number of intervals without rotation, or with the secondary
+// unreachable, after which the state manager is considered not ready.
+const staleIntervals = 3
+
 // StateManagerSingle implements a single-instance StateManagerPrimary for primary nodes
 type StateManagerSingle struct {
 	signer           crypto.Signer
 	storeSth         func(sth *types.SignedTreeHead) error
+	storeCosigned    func(cth *types.CosignedTreeHead) error
 	replicationState ReplicationState
 
 	// Lock-protected access to tree heads. All endpoints are readers.
 	sync.RWMutex
 	signedTreeHead   types.SignedTreeHead
 	cosignedTreeHead types.CosignedTreeHead
+	// Size of cosignedTreeHead, for waking up waiters on rotation.
+	published notify.Size
+
+	// Status for readiness checks, also protected by the lock.
+	interval           time.Duration
+	lastRotation       time.Time
+	secondaryDownSince time.Time // Zero if secondary is not known to be down
+	// When cosignatures were last collected, also protected by
+	// the lock.
+	lastCosigned time.Time
+
+	// Conditions for rotating before the end of the interval.
+	// Must be set before calling Run.
+	Trigger RotationTrigger
+	// If the tree is unchanged, and all witnesses have cosigned
+	// it, cosignatures are collected again only when they are
+	// this old. If zero, cosignatures are collected at every
+	// rotation. Must be set before calling Run.
+	CosignatureRefresh time.Duration
 }
 
 // NewStateManagerSingle() sets up a new state manager, in particular its
@@ -39,12 +69,15 @@ func NewStateManagerSingle(primary PrimaryTree, signer crypto.Signer, timeout ti
 	}
 
 	var sth types.SignedTreeHead
+	// No cosignatures available, unless restored below.
+	var cth *types.CosignedTreeHead
 	switch startupMode {
 	case StartupSaved:
 		sth, err = sthFile.Load(&pub)
 		if err != nil {
 			return nil, err
 		}
+		cth = loadCosigned(sthFile, &pub, &sth)
 	case StartupEmpty:
 		th := types.TreeHead{RootHash: crypto.HashBytes([]byte(""))}
 		sth, err = th.Sign(signer)
@@ -59,6 +92,10 @@ func NewStateManagerSingle(primary PrimaryTree, signer crypto.Signer, timeout ti
 		if err != nil {
 			return nil, err
 		}
+		cth, err = loadPromotedCosigned(context.Background(), primary, sthFile, &pub, &th)
+		if err != nil {
+			return nil, err
+		}
 		sth, err = th.Sign(signer)
 		if err != nil {
 			return nil, err
@@ -69,19 +106,90 @@ func NewStateManagerSingle(primary PrimaryTree, signer crypto.Signer, timeout ti
 	default:
 		panic(fmt.Sprintf("internal error, unknown startup mode %d", startupMode))
 	}
-	return &StateManagerSingle{
-		signer:   signer,
-		storeSth: sthFile.Store,
+	if cth == nil {
+		cth = &types.CosignedTreeHead{SignedTreeHead: sth}
+	}
+	sm := StateManagerSingle{
+		signer:        signer,
+		storeSth:      sthFile.Store,
+		storeCosigned: sthFile.StoreCosigned,
 		replicationState: ReplicationState{
 			primary:      primary,
 			secondary:    secondary,
 			secondaryPub: *secondaryPub,
 			timeout:      timeout,
 		},
-		// No cosignatures available at startup.
 		signedTreeHead:   sth,
-		cosignedTreeHead: types.CosignedTreeHead{SignedTreeHead: sth},
-	}, nil
+		cosignedTreeHead: *cth,
+	}
+	sm.published.Set(cth.Size)
+	return &sm, nil
+}
+
+// Loads the cosigned tree head published before the previous
+// shutdown, if any. It may be older than the sth, if shutdown
+// happened in the middle of a rotation, in which case it is
+// published again until next rotation. Returns nil if there's no
+// usable cosigned tree head.
+func loadCosigned(sthFile sthFile, pub *crypto.PublicKey, sth *types.SignedTreeHead) *types.CosignedTreeHead {
+	cth, err := sthFile.LoadCosigned(pub)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Warning("ignoring saved cosigned tree head: %v", err)
+		return nil
+	}
+	if cth.Size > sth.Size || (cth.Size == sth.Size && cth.RootHash != sth.RootHash) {
+		log.Warning("ignoring saved cosigned tree head, size %d, inconsistent with sth, size %d", cth.Size, sth.Size)
+		return nil
+	}
+	log.Info("restored cosigned tree head, size %d, %d cosignatures", cth.Size, len(cth.Cosignatures))
+	return &cth
+}
+
+// Loads the cosigned tree head last published by the old primary,
+// as stored by a secondary that is being promoted, and checks that
+// the local tree is consistent with it. Then it can be published
+// until the first rotation, so that cosignatures are available
+// immediately. Returns nil if there's no stored cosigned tree head,
+// and an error if the local tree isn't consistent with it. Only the
+// latest tree head is stored, each one replacing the previous, so
+// if the local tree is smaller, there's no older tree head to fall
+// back to, and promotion fails unless the file is removed.
+func loadPromotedCosigned(ctx context.Context, primary PrimaryTree, sthFile sthFile,
+	pub *crypto.PublicKey, th *types.TreeHead) (*types.CosignedTreeHead, error) {
+	cth, err := sthFile.LoadCosigned(pub)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Info("no cosigned tree head stored, starting without cosignatures")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case cth.Size > th.Size:
+		return nil, fmt.Errorf("local tree, size %d, is smaller than the published tree head, size %d", th.Size, cth.Size)
+	case cth.Size == th.Size:
+		if cth.RootHash != th.RootHash {
+			return nil, fmt.Errorf("local tree, size %d, has a different root hash than the published tree head", th.Size)
+		}
+	case cth.Size > 0:
+		proof, err := primary.GetConsistencyProof(ctx, &requests.ConsistencyProof{
+			OldSize: cth.Size,
+			NewSize: th.Size,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to get local consistency proof from %d to %d: %v", cth.Size, th.Size, err)
+		}
+		if err := proof.Verify(&cth.TreeHead, th); err != nil {
+			return nil, fmt.Errorf("local tree, size %d, not consistent with the published tree head, size %d: %v",
+				th.Size, cth.Size, err)
+		}
+	}
+	log.Info("local tree, size %d, consistent with the published tree head, size %d, %d cosignatures",
+		th.Size, cth.Size, len(cth.Cosignatures))
+	return &cth, nil
 }
 
 func (sm *StateManagerSingle) SignedTreeHead() types.SignedTreeHead {
@@ -96,28 +204,119 @@ func (sm *StateManagerSingle) CosignedTreeHead() types.CosignedTreeHead {
 	return sm.cosignedTreeHead
 }
 
+func (sm *StateManagerSingle) WaitCosignedTreeHead(ctx context.Context, size uint64) (types.CosignedTreeHead, error) {
+	if _, err := sm.published.Wait(ctx, size); err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	return sm.CosignedTreeHead(), nil
+}
+
+// Ready fails if the cosigned tree head hasn't been rotated for
+// staleIntervals intervals, or if the secondary has been unreachable
+// for as long. Always succeeds before Run is called.
+func (sm *StateManagerSingle) Ready() error {
+	sm.RLock()
+	defer sm.RUnlock()
+	if sm.interval == 0 {
+		return nil
+	}
+	threshold := staleIntervals * sm.interval
+	now := time.Now()
+	if age := now.Sub(sm.lastRotation); age > threshold {
+		return fmt.Errorf("tree head not rotated for %v", age.Round(time.Second))
+	}
+	if !sm.secondaryDownSince.IsZero() {
+		if down := now.Sub(sm.secondaryDownSince); down > threshold {
+			return fmt.Errorf("secondary unreachable for %v", down.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// Records whether or not the secondary could be reached when looking
+// for a new replicated tree head. Any response from the secondary
+// means that it is up, even if its tree head couldn't be used.
+func (sm *StateManagerSingle) updateSecondaryStatus(secondaryReached bool, err error) {
+	sm.Lock()
+	defer sm.Unlock()
+	switch {
+	case secondaryReached:
+		sm.secondaryDownSince = time.Time{}
+	case errors.Is(err, errSecondaryUnreachable):
+		if sm.secondaryDownSince.IsZero() {
+			sm.secondaryDownSince = time.Now()
+		}
+	}
+}
+
+// Run rotates tree heads once per interval, or earlier if triggered
+// by growth of the backend tree, until the context is cancelled. An
+// ongoing rotation is not interrupted by cancellation; Run returns
+// when it is completed and the new cosigned tree head is stored.
 func (sm *StateManagerSingle) Run(ctx context.Context, witnesses []policy.Entity, interval time.Duration) {
 	pub := sm.signer.Public()
 	collector := witness.NewCosignatureCollector(&pub, witnesses,
 		sm.replicationState.primary.GetConsistencyProof)
+	cth := sm.CosignedTreeHead()
+	collector.Restore(&cth)
+
+	sm.Lock()
+	sm.interval = interval
+	sm.lastRotation = time.Now()
+	sm.Unlock()
 
 	for ctx.Err() == nil {
-		rotateCtx, _ := context.WithTimeout(ctx, interval)
+		rotateStart := time.Now()
+		backendSize := sm.Trigger.size()
+		rotateCtx, cancel := context.WithDeadline(context.WithoutCancel(ctx), rotateStart.Add(interval))
+		// Correlates log messages, and requests to the
+		// secondary, for each rotation.
+		rotateCtx = logging.WithRequestID(rotateCtx, logging.NewRequestID())
+		spanCtx, span := tracing.Start(rotateCtx, "state.Rotate")
 
 		currentTH := sm.SignedTreeHead().TreeHead
-		nextTH, err := sm.replicationState.ReplicatedTreeHead(
-			rotateCtx, currentTH.Size)
+		nextTH, secondaryReached, err := sm.replicationState.ReplicatedTreeHead(
+			spanCtx, currentTH.Size)
+		sm.updateSecondaryStatus(secondaryReached, err)
 		if err != nil {
 			log.Error("no new replicated tree head: %v", err)
 			nextTH = currentTH
 		}
 
-		if err := sm.rotate(rotateCtx, &nextTH, collector.GetCosignatures); err != nil {
+		if sm.keepCosignatures(&nextTH, len(witnesses)) {
+			log.Debug("tree unchanged, keeping cosigned tree head: size %d", nextTH.Size)
+			span.SetAttributes("unchanged", true)
+		} else if err := sm.rotate(spanCtx, &nextTH, collector.GetCosignatures); err != nil {
 			log.Warning("failed rotating tree head: %v", err)
+			span.SetError(err)
 		}
-		// Waits until end of interval
-		<-rotateCtx.Done()
+		span.SetAttributes("size", nextTH.Size)
+		span.End()
+		// Waits until end of interval, or until triggered.
+		waitCtx, cancelWait := context.WithDeadline(ctx, rotateStart.Add(interval))
+		sm.Trigger.wait(waitCtx, sm.SignedTreeHead().Size, backendSize)
+		cancelWait()
+		cancel()
+	}
+}
+
+// Checks if the current cosigned tree head can be kept, rather than
+// rotated to nextTH: the tree is unchanged, all witnesses have
+// cosigned it, and the cosignatures are not older than
+// CosignatureRefresh. If so, it counts as a rotation for readiness
+// checks.
+func (sm *StateManagerSingle) keepCosignatures(nextTH *types.TreeHead, witnesses int) bool {
+	sm.Lock()
+	defer sm.Unlock()
+	now := time.Now()
+	if sm.CosignatureRefresh == 0 || sm.cosignedTreeHead.TreeHead != *nextTH ||
+		sm.signedTreeHead.TreeHead != *nextTH ||
+		len(sm.cosignedTreeHead.Cosignatures) < witnesses ||
+		now.Sub(sm.lastCosigned) >= sm.CosignatureRefresh {
+		return false
 	}
+	sm.lastRotation = now
+	return true
 }
 
 func (sm *StateManagerSingle) rotate(ctx context.Context, nextTH *types.TreeHead,
@@ -130,13 +329,23 @@ func (sm *StateManagerSingle) rotate(ctx context.Context, nextTH *types.TreeHead
 	// Blocks (with no locks held), potentially until context times out.
 	cosignatures := getCosignatures(ctx, &nextSTH)
 
+	cth := types.CosignedTreeHead{
+		SignedTreeHead: nextSTH,
+		Cosignatures:   cosignatures,
+	}
+	// Failure only affects restarts, so rotate anyway.
+	storeErr := sm.storeCosigned(&cth)
+
 	sm.Lock()
 	defer sm.Unlock()
 
 	log.Debug("rotating cosigned tree head: previous size %d, new size %d", sm.cosignedTreeHead.Size, nextSTH.Size)
-	sm.cosignedTreeHead = types.CosignedTreeHead{
-		SignedTreeHead: nextSTH,
-		Cosignatures:   cosignatures,
+	sm.cosignedTreeHead = cth
+	sm.lastRotation = time.Now()
+	sm.lastCosigned = sm.lastRotation
+	sm.published.Set(cth.Size)
+	if storeErr != nil {
+		return fmt.Errorf("storing cosigned tree head failed: %v", storeErr)
 	}
 	return nil
 }
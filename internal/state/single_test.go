@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/golang/mock/gomock"
+	memdb "sigsum.org/log-go/internal/db"
 	"sigsum.org/log-go/internal/mocks/db"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/types"
@@ -155,6 +156,7 @@ func TestRotate(t *testing.T) {
 		sth := mustSignTreehead(t, lSigner, table.signedSize)
 		nth := types.TreeHead{Size: table.nextSize}
 		var storedSth types.SignedTreeHead
+		var storedCth types.CosignedTreeHead
 		sm := StateManagerSingle{
 			signer:           signer,
 			cosignedTreeHead: types.CosignedTreeHead{SignedTreeHead: sth},
@@ -162,6 +164,10 @@ func TestRotate(t *testing.T) {
 				storedSth = *sth
 				return nil
 			},
+			storeCosigned: func(cth *types.CosignedTreeHead) error {
+				storedCth = *cth
+				return nil
+			},
 		}
 		err := sm.rotate(context.Background(), &nth, func(_ context.Context, sth *types.SignedTreeHead) map[crypto.Hash]types.Cosignature {
 			if !table.withCosignature {
@@ -192,6 +198,9 @@ func TestRotate(t *testing.T) {
 				t.Errorf("%s: unexpected cosigned tree head after rotation, got size %d, expected %d", table.desc, newCth.Size, table.nextSize)
 
 			}
+			if !reflect.DeepEqual(storedCth, newCth) {
+				t.Errorf("%s: unexpected stored cosigned tree head after rotation, got size %d, expected %d", table.desc, storedCth.Size, table.nextSize)
+			}
 			if table.withCosignature {
 				if len(newCth.Cosignatures) != 1 {
 					t.Fatalf("%s: unexpected cth cosignature count, got %d, expected 1", table.desc, len(newCth.Cosignatures))
@@ -215,6 +224,192 @@ func TestRotate(t *testing.T) {
 	}
 }
 
+func TestKeepCosignatures(t *testing.T) {
+	th := types.TreeHead{Size: 5, RootHash: crypto.Hash{1}}
+	now := time.Now()
+	cosignatures := map[crypto.Hash]types.Cosignature{crypto.Hash{2}: types.Cosignature{}}
+	for _, table := range []struct {
+		desc         string
+		refresh      time.Duration
+		nextTH       types.TreeHead
+		lastCosigned time.Time
+		witnesses    int
+		want         bool
+	}{
+		{desc: "unchanged", refresh: time.Hour, nextTH: th, lastCosigned: now, witnesses: 1, want: true},
+		{desc: "no witnesses", refresh: time.Hour, nextTH: th, lastCosigned: now, want: true},
+		{desc: "disabled", nextTH: th, lastCosigned: now, witnesses: 1},
+		{desc: "grown", refresh: time.Hour, nextTH: types.TreeHead{Size: 6}, lastCosigned: now, witnesses: 1},
+		{desc: "stale", refresh: time.Hour, nextTH: th, lastCosigned: now.Add(-2 * time.Hour), witnesses: 1},
+		{desc: "missing cosignature", refresh: time.Hour, nextTH: th, lastCosigned: now, witnesses: 2},
+	} {
+		sth := types.SignedTreeHead{TreeHead: th}
+		sm := StateManagerSingle{
+			signedTreeHead:     sth,
+			cosignedTreeHead:   types.CosignedTreeHead{SignedTreeHead: sth, Cosignatures: cosignatures},
+			lastCosigned:       table.lastCosigned,
+			CosignatureRefresh: table.refresh,
+		}
+		if got := sm.keepCosignatures(&table.nextTH, table.witnesses); got != table.want {
+			t.Errorf("%s: got %v, want %v", table.desc, got, table.want)
+		}
+		if got := !sm.lastRotation.IsZero(); got != table.want {
+			t.Errorf("%s: unexpected update of rotation time: %v", table.desc, got)
+		}
+	}
+}
+
+func TestRestoreCosigned(t *testing.T) {
+	lPub, lSigner := mustKeyPair(t)
+	wPub, wSigner := mustKeyPair(t)
+	wKeyHash := crypto.HashBytes(wPub[:])
+	origin := types.SigsumCheckpointOrigin(&lPub)
+
+	sth := mustSignTreehead(t, lSigner, 5)
+	cosigned := func(sth types.SignedTreeHead) *types.CosignedTreeHead {
+		return &types.CosignedTreeHead{
+			SignedTreeHead: sth,
+			Cosignatures: map[crypto.Hash]types.Cosignature{
+				wKeyHash: mustCosign(t, wSigner, &sth.TreeHead, origin)},
+		}
+	}
+	badSignature := cosigned(sth)
+	badSignature.Signature[0] ^= 1
+	for _, table := range []struct {
+		desc     string
+		cth      *types.CosignedTreeHead
+		restored bool
+	}{
+		{desc: "no file"},
+		{desc: "same size", cth: cosigned(sth), restored: true},
+		{desc: "older", cth: cosigned(mustSignTreehead(t, lSigner, 3)), restored: true},
+		{desc: "newer", cth: cosigned(mustSignTreehead(t, lSigner, 7))},
+		{desc: "different root hash", cth: cosigned(mustSign(t, lSigner, &types.TreeHead{Size: 5, RootHash: crypto.Hash{1}}))},
+		{desc: "bad signature", cth: badSignature},
+	} {
+		withTmpDir(t, func(dir string) {
+			file := sthFile{dir + "sth"}
+			if err := file.Store(&sth); err != nil {
+				t.Fatal(err)
+			}
+			if table.cth != nil {
+				if err := file.StoreCosigned(table.cth); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sm, err := NewStateManagerSingle(nil, lSigner, 0, nil, &crypto.PublicKey{}, file.name)
+			if err != nil {
+				t.Fatalf("%s: NewStateManagerSingle failed: %v", table.desc, err)
+			}
+			if got := sm.SignedTreeHead(); got != sth {
+				t.Errorf("%s: unexpected sth, got size %d, wanted %d", table.desc, got.Size, sth.Size)
+			}
+			want := types.CosignedTreeHead{SignedTreeHead: sth}
+			if table.restored {
+				want = *table.cth
+			}
+			if got := sm.CosignedTreeHead(); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: unexpected cosigned tree head, got %v, wanted %v", table.desc, got, want)
+			}
+		})
+	}
+}
+
+func TestPromoteCosigned(t *testing.T) {
+	ctx := context.Background()
+	lPub, lSigner := mustKeyPair(t)
+	wPub, wSigner := mustKeyPair(t)
+	wKeyHash := crypto.HashBytes(wPub[:])
+	origin := types.SigsumCheckpointOrigin(&lPub)
+
+	local := memdb.NewMemoryDb()
+	var leaves []types.Leaf
+	for i := 0; i < 5; i++ {
+		leaves = append(leaves, types.Leaf{Checksum: crypto.Hash{byte(i)}})
+	}
+	if err := local.AddSequencedLeaves(ctx, leaves, 0); err != nil {
+		t.Fatal(err)
+	}
+	older := memdb.NewMemoryDb()
+	if err := older.AddSequencedLeaves(ctx, leaves[:3], 0); err != nil {
+		t.Fatal(err)
+	}
+	th, err := local.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	olderTh, err := older.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The old primary's tree, with leaves the local tree lacks.
+	newer := memdb.NewMemoryDb()
+	if err := newer.AddSequencedLeaves(ctx, append(leaves, types.Leaf{Checksum: crypto.Hash{5}}, types.Leaf{Checksum: crypto.Hash{6}}), 0); err != nil {
+		t.Fatal(err)
+	}
+	newerTh, err := newer.GetTreeHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cosigned := func(th types.TreeHead) *types.CosignedTreeHead {
+		return &types.CosignedTreeHead{
+			SignedTreeHead: mustSign(t, lSigner, &th),
+			Cosignatures: map[crypto.Hash]types.Cosignature{
+				wKeyHash: mustCosign(t, wSigner, &th, origin)},
+		}
+	}
+	for _, table := range []struct {
+		desc     string
+		cth      *types.CosignedTreeHead
+		restored bool
+		wantErr  bool
+	}{
+		{desc: "no file"},
+		{desc: "same size", cth: cosigned(th), restored: true},
+		{desc: "older", cth: cosigned(olderTh), restored: true},
+		{desc: "newer", cth: cosigned(types.TreeHead{Size: 7}), wantErr: true},
+		// Only the latest tree head is stored, so there's no
+		// older one to fall back to.
+		{desc: "newer, extending local tree", cth: cosigned(newerTh), wantErr: true},
+		{desc: "different root hash", cth: cosigned(types.TreeHead{Size: 5, RootHash: crypto.Hash{1}}), wantErr: true},
+		{desc: "inconsistent", cth: cosigned(types.TreeHead{Size: 3, RootHash: crypto.Hash{1}}), wantErr: true},
+	} {
+		withTmpDir(t, func(dir string) {
+			file := sthFile{dir + "sth"}
+			if err := os.WriteFile(file.startupFileName(), []byte("startup=local-tree\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if table.cth != nil {
+				if err := file.StoreCosigned(table.cth); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sm, err := NewStateManagerSingle(local, lSigner, 0, nil, &crypto.PublicKey{}, file.name)
+			if got, want := err != nil, table.wantErr; got != want {
+				t.Fatalf("%s: unexpected result, got error %v, wanted error %v", table.desc, err, want)
+			}
+			if err != nil {
+				// Promotion can be retried, e.g., after
+				// removing the cosigned file.
+				if mode, err := file.Startup(); err != nil || mode != StartupLocalTree {
+					t.Errorf("%s: unexpected startup mode after failure, got %v, err %v", table.desc, mode, err)
+				}
+				return
+			}
+			if got := sm.SignedTreeHead(); got.TreeHead != th {
+				t.Errorf("%s: unexpected sth, got size %d, wanted %d", table.desc, got.Size, th.Size)
+			}
+			want := types.CosignedTreeHead{SignedTreeHead: sm.SignedTreeHead()}
+			if table.restored {
+				want = *table.cth
+			}
+			if got := sm.CosignedTreeHead(); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: unexpected cosigned tree head, got %v, wanted %v", table.desc, got, want)
+			}
+		})
+	}
+}
+
 func mustKeyPair(t *testing.T) (crypto.PublicKey, crypto.Signer) {
 	t.Helper()
 	pub, signer, err := crypto.NewKeyPair()
@@ -233,6 +428,15 @@ func mustCosign(t *testing.T, s crypto.Signer, th *types.TreeHead, origin string
 	return signature
 }
 
+func mustSign(t *testing.T, signer crypto.Signer, th *types.TreeHead) types.SignedTreeHead {
+	t.Helper()
+	sth, err := th.Sign(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sth
+}
+
 func mustSignTreehead(t *testing.T,
 	signer crypto.Signer, size uint64) types.SignedTreeHead {
 	t.Helper()
@@ -243,3 +447,63 @@ func mustSignTreehead(t *testing.T,
 	}
 	return sth
 }
+
+func TestReady(t *testing.T) {
+	now := time.Now()
+	for _, table := range []struct {
+		desc               string
+		interval           time.Duration
+		lastRotation       time.Time
+		secondaryDownSince time.Time
+		wantErr            bool
+	}{
+		{desc: "not running"},
+		{desc: "recently rotated", interval: time.Minute, lastRotation: now.Add(-time.Minute)},
+		{desc: "stale rotation", interval: time.Minute, lastRotation: now.Add(-4 * time.Minute), wantErr: true},
+		{desc: "secondary briefly down", interval: time.Minute, lastRotation: now,
+			secondaryDownSince: now.Add(-2 * time.Minute)},
+		{desc: "secondary down", interval: time.Minute, lastRotation: now,
+			secondaryDownSince: now.Add(-4 * time.Minute), wantErr: true},
+	} {
+		sm := StateManagerSingle{
+			interval:           table.interval,
+			lastRotation:       table.lastRotation,
+			secondaryDownSince: table.secondaryDownSince,
+		}
+		if err := sm.Ready(); (err != nil) != table.wantErr {
+			t.Errorf("%s: unexpected result from Ready: %v", table.desc, err)
+		}
+	}
+}
+
+func TestUpdateSecondaryStatus(t *testing.T) {
+	sm := StateManagerSingle{}
+	sm.updateSecondaryStatus(false, fmt.Errorf("wrapped: %w", errSecondaryUnreachable))
+	downSince := sm.secondaryDownSince
+	if downSince.IsZero() {
+		t.Fatalf("secondary not marked as down")
+	}
+	sm.updateSecondaryStatus(false, fmt.Errorf("wrapped: %w", errSecondaryUnreachable))
+	if sm.secondaryDownSince != downSince {
+		t.Errorf("time secondary went down was updated")
+	}
+	sm.updateSecondaryStatus(false, fmt.Errorf("get primary tree head: backend failure"))
+	if sm.secondaryDownSince.IsZero() {
+		t.Errorf("secondary marked as up, without being contacted")
+	}
+	// A response from the secondary, even with an unusable
+	// tree head, means that it is up.
+	sm.updateSecondaryStatus(true, fmt.Errorf("secondary is behind: 3 < 4"))
+	if !sm.secondaryDownSince.IsZero() {
+		t.Errorf("secondary not marked as up")
+	}
+	sm.updateSecondaryStatus(false, fmt.Errorf("wrapped: %w", errSecondaryUnreachable))
+	if sm.secondaryDownSince.IsZero() {
+		t.Fatalf("secondary not marked as down")
+	}
+	// Recovery without any growth of the tree.
+	sm.updateSecondaryStatus(true, nil)
+	if !sm.secondaryDownSince.IsZero() {
+		t.Errorf("secondary not marked as up")
+	}
+}
@@ -15,6 +15,15 @@ type StateManager interface {
 	SignedTreeHead() types.SignedTreeHead
 	// Currently published tree.
 	CosignedTreeHead() types.CosignedTreeHead
+	// WaitCosignedTreeHead blocks until the published tree is
+	// larger than size, and returns it, or fails if the context
+	// is done first.
+	WaitCosignedTreeHead(ctx context.Context, size uint64) (types.CosignedTreeHead, error)
+
+	// Ready returns an error if tree heads are not rotated as
+	// expected, or if the secondary has been unreachable for too
+	// long.
+	Ready() error
 
 	// Run periodically rotates the node's tree heads and queries witnesses.
 	Run(context.Context, []policy.Entity, time.Duration)
@@ -31,12 +31,18 @@ const (
 	StartupLocalTree
 
 	StartupFileSuffix = ".startup"
+	// Cosigned tree head, stored next to the sth file.
+	CosignedFileSuffix = ".cosigned"
 )
 
 func (s sthFile) startupFileName() string {
 	return s.name + StartupFileSuffix
 }
 
+func (s sthFile) cosignedFileName() string {
+	return s.name + CosignedFileSuffix
+}
+
 func parseStartupFile(f io.Reader) (StartupMode, error) {
 	// TODO: Add a GetString method to sigsum-go's ascii.Parser?
 	scanner := bufio.NewScanner(f)
@@ -135,3 +141,37 @@ func (s sthFile) Store(sth *types.SignedTreeHead) error {
 	// Atomically replace old file with new.
 	return f.Commit()
 }
+
+// Loads the latest published cosigned tree head. Cosignatures were
+// verified when collected, only the log's signature is checked here.
+func (s sthFile) LoadCosigned(pub *crypto.PublicKey) (types.CosignedTreeHead, error) {
+	name := s.cosignedFileName()
+	f, err := os.Open(name)
+	if err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	defer f.Close()
+	var cth types.CosignedTreeHead
+	if err := cth.FromASCII(f); err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	if !cth.Verify(pub) {
+		return types.CosignedTreeHead{}, fmt.Errorf("invalid signature in file %q", name)
+	}
+	return cth, nil
+}
+
+func (s sthFile) StoreCosigned(cth *types.CosignedTreeHead) error {
+	f, err := safefile.Create(s.cosignedFileName(), 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := cth.ToASCII(f); err != nil {
+		return err
+	}
+
+	// Atomically replace old file with new.
+	return f.Commit()
+}
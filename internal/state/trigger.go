@@ -0,0 +1,78 @@
+package state
+
+import (
+	"context"
+	"time"
+
+	"sigsum.org/log-go/internal/notify"
+)
+
+// RotationTrigger configures rotation before the end of the
+// interval, based on growth of the backend tree. The interval is
+// still the maximum time between rotations, so that witnesses get
+// regular heartbeats.
+type RotationTrigger struct {
+	// Size of the backend tree. Early rotation is disabled if nil.
+	BackendSize *notify.Size
+	// Rotate when the backend tree has grown by this many leaves
+	// since the previous rotation. Zero to disable.
+	Leaves uint64
+	// Rotate when this much time has passed since a leaf added
+	// after the previous rotation started was first seen. Zero to
+	// disable.
+	Delay time.Duration
+}
+
+func (t *RotationTrigger) enabled() bool {
+	return t.BackendSize != nil && (t.Leaves > 0 || t.Delay > 0)
+}
+
+// Returns the current backend size, or zero if not available.
+func (t *RotationTrigger) size() uint64 {
+	if t.BackendSize == nil {
+		return 0
+	}
+	return t.BackendSize.Get()
+}
+
+// Blocks until the next rotation is due, i.e., the context is done,
+// or a trigger condition is met. The signedSize is the size of the
+// latest signed tree head, and baseline is the backend size when the
+// previous rotation started.
+func (t *RotationTrigger) wait(ctx context.Context, signedSize, baseline uint64) {
+	if !t.enabled() {
+		<-ctx.Done()
+		return
+	}
+	// Leaves are counted, and the delay started, from the backend
+	// size as of the previous rotation, which may be larger than
+	// the signed size if the secondary is behind. Using the signed
+	// size would then trigger rotation repeatedly without any new
+	// leaves.
+	if baseline < signedSize {
+		baseline = signedSize
+	}
+	var pendingSince time.Time
+	if t.BackendSize.Get() > baseline {
+		pendingSince = time.Now()
+	}
+	for size := baseline; ; {
+		waitCtx, cancel := ctx, func() {}
+		if t.Delay > 0 && !pendingSince.IsZero() {
+			waitCtx, cancel = context.WithDeadline(ctx, pendingSince.Add(t.Delay))
+		}
+		current, err := t.BackendSize.Wait(waitCtx, size)
+		cancel()
+		if err != nil {
+			// Either ctx is done, or the delay expired.
+			return
+		}
+		if pendingSince.IsZero() {
+			pendingSince = time.Now()
+		}
+		if t.Leaves > 0 && current-baseline >= t.Leaves {
+			return
+		}
+		size = current
+	}
+}
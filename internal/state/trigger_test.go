@@ -0,0 +1,140 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sigsum.org/log-go/internal/notify"
+)
+
+// Runs wait in the background, with the current backend size as
+// baseline, returning a channel that is closed when it returns.
+func startWait(ctx context.Context, t *RotationTrigger, signedSize uint64) <-chan struct{} {
+	return startWaitBaseline(ctx, t, signedSize, t.size())
+}
+
+func startWaitBaseline(ctx context.Context, t *RotationTrigger, signedSize, baseline uint64) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t.wait(ctx, signedSize, baseline)
+	}()
+	return done
+}
+
+func isDone(done <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestTriggerDisabled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// Not enabled, since no trigger condition is set.
+	trigger := RotationTrigger{BackendSize: &notify.Size{}}
+	trigger.BackendSize.Set(100)
+	trigger.wait(ctx, 0, 0)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("wait returned early, after %v", elapsed)
+	}
+}
+
+func TestTriggerLeaves(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	trigger := RotationTrigger{BackendSize: &notify.Size{}, Leaves: 10}
+	trigger.BackendSize.Set(5)
+	done := startWait(ctx, &trigger, 5)
+
+	trigger.BackendSize.Set(14)
+	if isDone(done, 50*time.Millisecond) {
+		t.Fatalf("triggered by 9 leaves")
+	}
+	trigger.BackendSize.Set(15)
+	if !isDone(done, time.Second) {
+		t.Fatalf("not triggered by 10 leaves")
+	}
+}
+
+func TestTriggerLeavesBehind(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Leaves are counted from the backend size, not the signed
+	// size, e.g., if the secondary is behind.
+	trigger := RotationTrigger{BackendSize: &notify.Size{}, Leaves: 10}
+	trigger.BackendSize.Set(20)
+	done := startWait(ctx, &trigger, 5)
+	if isDone(done, 50*time.Millisecond) {
+		t.Fatalf("triggered by leaves not yet replicated")
+	}
+	trigger.BackendSize.Set(30)
+	if !isDone(done, time.Second) {
+		t.Fatalf("not triggered by 10 new leaves")
+	}
+}
+
+func TestTriggerDelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	delay := 100 * time.Millisecond
+	trigger := RotationTrigger{BackendSize: &notify.Size{}, Delay: delay}
+	trigger.BackendSize.Set(5)
+	done := startWait(ctx, &trigger, 5)
+	if isDone(done, 2*delay) {
+		t.Fatalf("triggered without new leaves")
+	}
+	start := time.Now()
+	trigger.BackendSize.Set(6)
+	// Further leaves don't postpone rotation.
+	time.Sleep(delay / 2)
+	trigger.BackendSize.Set(7)
+	if !isDone(done, time.Second) {
+		t.Fatalf("not triggered after delay")
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("triggered after %v, before delay %v", elapsed, delay)
+	}
+}
+
+func TestTriggerDelayPending(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Leaf added during the previous rotation, pending already at
+	// start.
+	delay := 100 * time.Millisecond
+	trigger := RotationTrigger{BackendSize: &notify.Size{}, Delay: delay}
+	trigger.BackendSize.Set(6)
+	done := startWaitBaseline(ctx, &trigger, 5, 5)
+	if !isDone(done, time.Second) {
+		t.Fatalf("not triggered after delay")
+	}
+}
+
+func TestTriggerDelayBehind(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Leaves not yet replicated, but seen by the previous
+	// rotation, don't start the delay.
+	delay := 50 * time.Millisecond
+	trigger := RotationTrigger{BackendSize: &notify.Size{}, Delay: delay}
+	trigger.BackendSize.Set(20)
+	done := startWait(ctx, &trigger, 5)
+	if isDone(done, 4*delay) {
+		t.Fatalf("triggered without new leaves")
+	}
+	trigger.BackendSize.Set(21)
+	if !isDone(done, time.Second) {
+		t.Fatalf("not triggered after delay")
+	}
+}
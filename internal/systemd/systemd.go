@@ -0,0 +1,173 @@
+// Package systemd implements the parts of the systemd service
+// protocols used by the log servers: socket activation, see
+// sd_listen_fds(3), and readiness and watchdog notifications, see
+// sd_notify(3).
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+// First file descriptor passed by systemd, a variable for testing.
+var listenFdsStart = 3
+
+// Listeners returns the sockets passed by systemd socket activation,
+// keyed by name, as specified by FileDescriptorName= in the socket
+// unit. Only sockets with one of the given names are used, others
+// are closed, with a warning. Returns an empty map if the process
+// was not socket activated. The environment variables are unset, so
+// that they are not inherited by child processes.
+func Listeners(names ...string) (map[string]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make(map[string]net.Listener)
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return listeners, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %q", os.Getenv("LISTEN_FDS"))
+	}
+	var fdNames []string
+	if s := os.Getenv("LISTEN_FDNAMES"); s != "" {
+		fdNames = strings.Split(s, ":")
+	}
+	for i := 0; i < count; i++ {
+		name := "unknown"
+		if i < len(fdNames) {
+			name = fdNames[i]
+		}
+		f := os.NewFile(uintptr(listenFdsStart+i), name)
+		if !slices.Contains(names, name) {
+			// E.g., the default name, which is the name of
+			// the socket unit.
+			log.Warning("ignoring socket named %q from systemd, FileDescriptorName= must be one of %q",
+				name, names)
+			f.Close()
+			continue
+		}
+		l, err := net.FileListener(f)
+		// FileListener dups the descriptor.
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %q from systemd is not a listening socket: %v", name, err)
+		}
+		if _, ok := listeners[name]; ok {
+			return nil, fmt.Errorf("multiple sockets named %q from systemd", name)
+		}
+		listeners[name] = l
+	}
+	return listeners, nil
+}
+
+// Notify sends a status notification, e.g., "READY=1", to systemd.
+// Does nothing if NOTIFY_SOCKET is unset, i.e., if the service is not
+// run by systemd, or not with Type=notify.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		// Abstract namespace socket.
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("connecting to systemd notify socket failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("sending systemd notification failed: %v", err)
+	}
+	return nil
+}
+
+// NotifyReady waits until check succeeds, retrying once per second,
+// and then notifies systemd that the service is ready. Returns early
+// if the context is cancelled.
+func NotifyReady(ctx context.Context, check func(context.Context) error) {
+	for {
+		err := check(ctx)
+		if err == nil {
+			break
+		}
+		log.Warning("not ready: %v", err)
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+	if err := Notify("READY=1"); err != nil {
+		log.Warning("%v", err)
+	}
+}
+
+// WatchdogInterval returns the watchdog timeout configured by
+// WatchdogSec=, or zero if the watchdog is disabled.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseUint(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec == 0 {
+		return 0
+	}
+	if s := os.Getenv("WATCHDOG_PID"); s != "" {
+		if pid, err := strconv.Atoi(s); err != nil || pid != os.Getpid() {
+			return 0
+		}
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Watchdog pings the systemd watchdog at half the configured
+// timeout, for as long as check succeeds, until the context is
+// cancelled. If check fails, no ping is sent, so that systemd
+// eventually restarts the service. Does nothing if the watchdog is
+// disabled.
+func Watchdog(ctx context.Context, check func(context.Context) error) {
+	interval := WatchdogInterval() / 2
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		err := check(checkCtx)
+		cancel()
+		if err != nil {
+			log.Warning("health check failed, not pinging systemd watchdog: %v", err)
+			continue
+		}
+		if err := Notify("WATCHDOG=1"); err != nil {
+			log.Warning("%v", err)
+		}
+	}
+}
+
+// Listen returns the socket activated listener with the given name,
+// if any, otherwise it creates a new TCP listener on addr.
+func Listen(listeners map[string]net.Listener, name, addr string) (net.Listener, error) {
+	if l, ok := listeners[name]; ok {
+		log.Info("using socket %q from systemd for %s endpoint", l.Addr(), name)
+		return l, nil
+	}
+	return net.Listen("tcp", addr)
+}
@@ -0,0 +1,158 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestListenersNotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "2")
+	listeners, err := Listeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 0 {
+		t.Errorf("unexpected listeners for other pid: %v", listeners)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Errorf("LISTEN_FDS not unset")
+	}
+}
+
+// Passes listeners as consecutive descriptors, as systemd would, and
+// returns the first descriptor.
+func mustPassListeners(t *testing.T, listeners []*net.TCPListener) int {
+	t.Helper()
+	isOpen := func(fd int) bool {
+		_, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+		return err == nil
+	}
+	start := 100
+	for fd := start; fd < start+len(listeners); fd++ {
+		if isOpen(fd) {
+			start = fd + 1
+		}
+	}
+	for i, l := range listeners {
+		f, err := l.File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := unix.Dup2(int(f.Fd()), start+i); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	return start
+}
+
+func TestListeners(t *testing.T) {
+	var tcpListeners []*net.TCPListener
+	for i := 0; i < 3; i++ {
+		l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		tcpListeners = append(tcpListeners, l)
+	}
+	start := mustPassListeners(t, tcpListeners)
+	defer func(old int) { listenFdsStart = old }(listenFdsStart)
+	listenFdsStart = start
+
+	t.Setenv("LISTEN_PID", fmt.Sprint(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "3")
+	// The middle one has the default name, i.e., the socket unit's
+	// name, and is not used.
+	t.Setenv("LISTEN_FDNAMES", "internal:sigsum-log.socket:external")
+	listeners, err := Listeners("internal", "external")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range listeners {
+		defer l.Close()
+	}
+	if got, want := len(listeners), 2; got != want {
+		t.Fatalf("unexpected number of listeners, got %d, wanted %d", got, want)
+	}
+	for i, name := range []string{"internal", "", "external"} {
+		if name == "" {
+			continue
+		}
+		l, ok := listeners[name]
+		if !ok {
+			t.Errorf("missing listener %q", name)
+			continue
+		}
+		if got, want := l.Addr().String(), tcpListeners[i].Addr().String(); got != want {
+			t.Errorf("unexpected address for listener %q, got %s, wanted %s", name, got, want)
+		}
+	}
+	if _, err := unix.FcntlInt(uintptr(start+1), unix.F_GETFD, 0); err == nil {
+		t.Errorf("unused socket from systemd not closed")
+	}
+
+	l, err := Listen(listeners, "internal", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l != listeners["internal"] {
+		t.Errorf("Listen didn't use the listener from systemd")
+	}
+}
+
+func mustListenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NOTIFY_SOCKET", socket)
+	return conn
+}
+
+func mustReceive(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 100)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	conn := mustListenNotify(t)
+	defer conn.Close()
+
+	if err := Notify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mustReceive(t, conn), "READY=1"; got != want {
+		t.Errorf("unexpected notification, got %q, wanted %q", got, want)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	conn := mustListenNotify(t)
+	defer conn.Close()
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", fmt.Sprint(os.Getpid()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Watchdog(ctx, func(context.Context) error { return nil })
+	if got, want := mustReceive(t, conn), "WATCHDOG=1"; got != want {
+		t.Errorf("unexpected notification, got %q, wanted %q", got, want)
+	}
+}
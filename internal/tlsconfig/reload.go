@@ -0,0 +1,80 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+// CertReloader holds a server certificate, which can be reloaded
+// from file without restarting the server.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewCertReloader loads the certificate and key from the given files.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Reload reads the certificate and key files again. On failure, the
+// previous certificate is kept.
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed loading TLS certificate: %v", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	return nil
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// ServerConfig returns a TLS configuration using the current
+// certificate, with HTTP/2 enabled.
+func (r *CertReloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: r.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
+
+// ReloadOnSignal reloads the certificate each time one of the given
+// signals is received, until the context is cancelled.
+func (r *CertReloader) ReloadOnSignal(ctx context.Context, sig ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig...)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ch:
+			if err := r.Reload(); err != nil {
+				log.Error("reloading certificate failed, keeping old one: %v", err)
+			} else {
+				log.Info("reloaded certificate from %s", r.certFile)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package tlsconfig sets up TLS for the internal endpoints, used for
+// replication between primary and secondary nodes, and for the
+// connection to Trillian. When a CA file is configured for the
+// internal endpoints, both sides authenticate each other, using
+// certificates issued by that CA.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Config lists the files used for internal TLS. If CertFile is
+// empty, TLS is disabled.
+type Config struct {
+	CertFile string // PEM certificate, used both as server and client certificate
+	KeyFile  string // PEM private key corresponding to CertFile
+	CAFile   string // PEM CA certificate(s), used to verify peers
+}
+
+func (c *Config) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// ServerConfig returns the TLS configuration for the internal
+// endpoint, or nil if TLS is disabled. If a CA file is configured,
+// clients must present a certificate issued by that CA.
+func (c *Config) ServerConfig() (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading internal TLS certificate: %v", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.CAFile != "" {
+		pool, err := readCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// ClientConfig returns the TLS configuration for connecting to a
+// server. If CertFile is set, it is presented as client certificate,
+// and if CAFile is set, it is used to verify the server's
+// certificate; otherwise the system's root CAs are used. Returns nil
+// if neither is set.
+func (c *Config) ClientConfig() (*tls.Config, error) {
+	if c.CertFile == "" && c.CAFile == "" {
+		return nil, nil
+	}
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading TLS client certificate: %v", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if c.CAFile != "" {
+		pool, err := readCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}
+
+// HTTPClient returns an http client for requests to the internal
+// endpoint of another node, presenting our certificate and verifying
+// the server's certificate using the configured CA. Returns nil if
+// TLS is disabled, in which case the caller should use its default
+// client.
+func (c *Config) HTTPClient() (*http.Client, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	conf, err := c.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = conf
+	return &http.Client{Transport: transport}, nil
+}
+
+func readCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid certificates in CA file %q", file)
+	}
+	return pool, nil
+}
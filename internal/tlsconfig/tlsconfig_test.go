@@ -0,0 +1,170 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := mustCreateCert(t, dir, "ca", nil, nil)
+	mustCreateCert(t, dir, "node", ca, caKey)
+	mustCreateCert(t, dir, "other", nil, nil) // Not issued by ca.
+
+	nodeConf := Config{
+		CertFile: filepath.Join(dir, "node.pem"),
+		KeyFile:  filepath.Join(dir, "node.key"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+	}
+	serverConf, err := nodeConf.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	server.TLS = serverConf
+	server.StartTLS()
+	defer server.Close()
+
+	client, err := nodeConf.HTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request with valid client certificate failed: %v", err)
+	}
+	rsp.Body.Close()
+
+	otherConf := nodeConf
+	otherConf.CertFile = filepath.Join(dir, "other.pem")
+	otherConf.KeyFile = filepath.Join(dir, "other.key")
+	client, err = otherConf.HTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp, err := client.Get(server.URL); err == nil {
+		rsp.Body.Close()
+		t.Errorf("request with invalid client certificate succeeded")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	conf := Config{}
+	if c, err := conf.ServerConfig(); c != nil || err != nil {
+		t.Errorf("unexpected server config for disabled TLS: %v, %v", c, err)
+	}
+	if c, err := conf.HTTPClient(); c != nil || err != nil {
+		t.Errorf("unexpected client for disabled TLS: %v, %v", c, err)
+	}
+}
+
+// Creates a certificate valid for 127.0.0.1, and writes it, and the
+// private key, to <dir>/<name>.pem and <dir>/<name>.key. If parent is
+// nil, the certificate is a self-signed CA certificate.
+func mustCreateCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	mustCreateCert(t, dir, "first", nil, nil)
+	mustCreateCert(t, dir, "second", nil, nil)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "cert.key")
+	mustRename := func(name string) {
+		t.Helper()
+		for _, ext := range []string{".pem", ".key"} {
+			if err := os.Rename(filepath.Join(dir, name+ext), filepath.Join(dir, "cert"+ext)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	commonName := func(r *CertReloader) string {
+		t.Helper()
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return x509Cert.Subject.CommonName
+	}
+
+	mustRename("first")
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := commonName(r), "first"; got != want {
+		t.Errorf("unexpected certificate, got %q, wanted %q", got, want)
+	}
+	mustRename("second")
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := commonName(r), "second"; got != want {
+		t.Errorf("unexpected certificate after reload, got %q, wanted %q", got, want)
+	}
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Errorf("reload with missing key file succeeded")
+	}
+	if got, want := commonName(r), "second"; got != want {
+		t.Errorf("unexpected certificate after failed reload, got %q, wanted %q", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Header, and grpc metadata key, used to propagate trace context.
+const TraceparentHeader = "traceparent"
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Middleware starts a server span for each request, as a child of
+// the trace context in the request's traceparent header, if any.
+func Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if active.Load() == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		if parent, ok := parseTraceparent(r.Header.Get(TraceparentHeader)); ok {
+			ctx = context.WithValue(ctx, spanKey{}, parent)
+		}
+		ctx, span := start(ctx, r.Method+" "+r.URL.Path, kindServer,
+			[]any{"http.request.method", r.Method, "url.path", r.URL.Path})
+		defer span.End()
+
+		rec := statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(&rec, r.WithContext(ctx))
+		span.SetAttributes("http.response.status_code", rec.status)
+		if rec.status >= 500 {
+			span.SetError(fmt.Errorf("status %d", rec.status))
+		}
+	})
+}
+
+type roundTripper struct {
+	next http.RoundTripper
+}
+
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := start(req.Context(), req.Method, kindClient,
+		[]any{"http.request.method", req.Method, "url.full", req.URL.String()})
+	if span == nil {
+		return t.next.RoundTrip(req)
+	}
+	defer span.End()
+
+	// RoundTrippers must not modify the request.
+	req = req.Clone(ctx)
+	req.Header.Set(TraceparentHeader, span.traceparent())
+	rsp, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttributes("http.response.status_code", rsp.StatusCode)
+	if rsp.StatusCode >= 500 {
+		span.SetError(fmt.Errorf("status %d", rsp.StatusCode))
+	}
+	return rsp, nil
+}
+
+// HTTPClient returns a client which starts a client span for each
+// request, and propagates it in a traceparent header. If c is nil, the
+// default transport is used.
+func HTTPClient(c *http.Client) *http.Client {
+	var client http.Client
+	if c != nil {
+		client = *c
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = roundTripper{next}
+	return &client
+}
+
+// UnaryClientInterceptor starts a client span for each grpc call, and
+// propagates it as outgoing grpc metadata.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply any,
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, span := start(ctx, method, kindClient, []any{"rpc.system", "grpc", "rpc.method", method})
+	if span == nil {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	defer span.End()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, TraceparentHeader, span.traceparent())
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err != nil {
+		span.SetAttributes("rpc.grpc.status_code", int(status.Code(err)))
+		span.SetError(err)
+	}
+	return err
+}
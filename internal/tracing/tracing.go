@@ -0,0 +1,299 @@
+// Package tracing implements optional distributed tracing, with spans
+// exported to an OpenTelemetry collector using OTLP over HTTP, in
+// the JSON encoding. Trace context is propagated using the W3C
+// traceparent header, for both HTTP and grpc requests.
+//
+// Tracing is disabled until Setup is called; when disabled, Start
+// returns a nil *Span, and all Span methods are no-ops on nil.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+const (
+	// Queued spans are exported once per exportInterval, spans
+	// are dropped if the queue is full.
+	exportInterval  = 5 * time.Second
+	exportQueueSize = 2048
+	exportTimeout   = 10 * time.Second
+)
+
+// Span kinds, as defined by OTLP.
+const (
+	kindInternal = 1
+	kindServer   = 2
+	kindClient   = 3
+)
+
+// Span represents a timed operation. Trace and span ids are hex
+// encoded.
+type Span struct {
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	kind     int
+	start    time.Time
+
+	mu    sync.Mutex
+	attrs []any
+	err   error
+	ended bool
+}
+
+type exporter struct {
+	url         string
+	serviceName string
+	stop        chan struct{}
+	done        chan struct{}
+
+	mu      sync.Mutex
+	queue   []any
+	dropped int
+}
+
+// The active exporter, nil if tracing is disabled.
+var active atomic.Pointer[exporter]
+
+// Context key for the current span, or for the remote parent
+// extracted from an incoming request.
+type spanKey struct{}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func start(ctx context.Context, name string, kind int, attrs []any) (context.Context, *Span) {
+	if active.Load() == nil {
+		return ctx, nil
+	}
+	s := Span{name: name, kind: kind, start: time.Now(), attrs: attrs, spanID: newID(8)}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok && parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		s.traceID = newID(16)
+	}
+	return context.WithValue(ctx, spanKey{}, &s), &s
+}
+
+// Start starts a new span, as a child of any span in the context.
+// Attributes are given as alternating keys and values. The returned
+// context carries the new span.
+func Start(ctx context.Context, name string, attrs ...any) (context.Context, *Span) {
+	return start(ctx, name, kindInternal, attrs)
+}
+
+// SetAttributes adds attributes, given as alternating keys and
+// values.
+func (s *Span) SetAttributes(attrs ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// SetError marks the span as failed, if err is non-nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// End ends the span, and queues it for export.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	span := s.toOTLP(end)
+	s.mu.Unlock()
+
+	if e := active.Load(); e != nil {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if len(e.queue) >= exportQueueSize {
+			// Logged by the export goroutine, to avoid
+			// log spam from request handlers.
+			e.dropped++
+			return
+		}
+		e.queue = append(e.queue, span)
+	}
+}
+
+// Formats the W3C traceparent header value, with the sampled flag set.
+func (s *Span) traceparent() string {
+	return "00-" + s.traceID + "-" + s.spanID + "-01"
+}
+
+// Parses a W3C traceparent header value, into a span usable only as
+// a remote parent. Later versions may append more fields, which we
+// ignore.
+func parseTraceparent(value string) (*Span, bool) {
+	f := strings.Split(value, "-")
+	if len(f) < 4 || len(f[0]) != 2 || f[0] == "ff" || !isID(f[1], 16) || !isID(f[2], 8) {
+		return nil, false
+	}
+	return &Span{traceID: f[1], spanID: f[2]}, true
+}
+
+// Checks for a hex encoded, non-zero, id of n bytes.
+func isID(s string, n int) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == n && strings.Trim(s, "0") != ""
+}
+
+// Setup enables tracing, with spans exported to the OTLP/HTTP
+// collector at endpoint, e.g., "http://localhost:4318". Returns a
+// function that exports any queued spans and disables tracing.
+func Setup(endpoint, serviceName string) func(context.Context) {
+	e := &exporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	active.Store(e)
+	go e.run()
+	return func(ctx context.Context) {
+		active.Store(nil)
+		close(e.stop)
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.flush()
+		case <-e.stop:
+			e.flush()
+			return
+		}
+	}
+}
+
+func (e *exporter) flush() {
+	e.mu.Lock()
+	spans, dropped := e.queue, e.dropped
+	e.queue, e.dropped = nil, 0
+	e.mu.Unlock()
+
+	if dropped > 0 {
+		log.Warning("trace span queue full, dropped %d spans", dropped)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	if err := e.export(spans); err != nil {
+		log.Warning("exporting %d trace spans failed: %v", len(spans), err)
+	}
+}
+
+// Posts spans to the collector, using the OTLP JSON encoding, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+func (e *exporter) export(spans []any) error {
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes([]any{"service.name", e.serviceName}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "sigsum.org/log-go"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from collector: %s", rsp.Status)
+	}
+	return nil
+}
+
+// Must be called with s.mu held.
+func (s *Span) toOTLP(end time.Time) map[string]any {
+	span := map[string]any{
+		"traceId":           s.traceID,
+		"spanId":            s.spanID,
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": fmt.Sprint(s.start.UnixNano()),
+		"endTimeUnixNano":   fmt.Sprint(end.UnixNano()),
+		"attributes":        otlpAttributes(s.attrs),
+	}
+	if s.parentID != "" {
+		span["parentSpanId"] = s.parentID
+	}
+	if s.err != nil {
+		span["status"] = map[string]any{"code": 2, "message": s.err.Error()}
+	}
+	return span
+}
+
+func otlpAttributes(kv []any) []any {
+	attrs := []any{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		var value map[string]any
+		switch v := kv[i+1].(type) {
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int, int64, uint64:
+			// 64-bit integers are encoded as strings.
+			value = map[string]any{"intValue": fmt.Sprint(v)}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		attrs = append(attrs, map[string]any{"key": fmt.Sprint(kv[i]), "value": value})
+	}
+	return attrs
+}
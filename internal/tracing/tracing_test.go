@@ -0,0 +1,190 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// The parts of the OTLP JSON encoding checked by the tests.
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type otlpSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Attributes   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			IntValue *string `json:"intValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+	Status otlpStatus `json:"status"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []otlpSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+// Mock collector, recording all received spans.
+type collector struct {
+	mu    sync.Mutex
+	spans []otlpSpan
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+		return
+	}
+	var req otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+}
+
+func (c *collector) byName() map[string]otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]otlpSpan)
+	for _, s := range c.spans {
+		m[s.Name] = s
+	}
+	return m
+}
+
+func setupCollector(t *testing.T) (*collector, func()) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	shutdown := Setup(srv.URL, "test")
+	return c, func() {
+		shutdown(context.Background())
+		srv.Close()
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	ctx, span := Start(context.Background(), "disabled")
+	if span != nil {
+		t.Errorf("got span %v, while tracing disabled", span)
+	}
+	// Must not crash.
+	span.SetAttributes("foo", 1)
+	span.SetError(errors.New("foo"))
+	span.End()
+	if ctx.Value(spanKey{}) != nil {
+		t.Errorf("unexpected span in context")
+	}
+}
+
+func TestExport(t *testing.T) {
+	c, shutdown := setupCollector(t)
+
+	ctx, parent := Start(context.Background(), "parent", "size", uint64(17))
+	_, child := Start(ctx, "child")
+	child.SetError(errors.New("mock error"))
+	child.End()
+	parent.End()
+	shutdown()
+
+	spans := c.byName()
+	if len(spans) != 2 {
+		t.Fatalf("unexpected spans: %v", spans)
+	}
+	p, ch := spans["parent"], spans["child"]
+	if p.ParentSpanID != "" {
+		t.Errorf("unexpected parent of root span: %q", p.ParentSpanID)
+	}
+	if ch.TraceID != p.TraceID || ch.ParentSpanID != p.SpanID {
+		t.Errorf("child not linked to parent, child: %v, parent: %v", ch, p)
+	}
+	if got, want := ch.Status, (otlpStatus{Code: 2, Message: "mock error"}); got != want {
+		t.Errorf("unexpected child status, got %v, want %v", got, want)
+	}
+	if len(p.Attributes) != 1 || p.Attributes[0].Key != "size" ||
+		p.Attributes[0].Value.IntValue == nil || *p.Attributes[0].Value.IntValue != "17" {
+		t.Errorf("unexpected parent attributes: %v", p.Attributes)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	c, shutdown := setupCollector(t)
+
+	// Backend records the traceparent it receives.
+	var received string
+	backend := httptest.NewServer(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(TraceparentHeader)
+	})))
+	defer backend.Close()
+
+	ctx, root := Start(context.Background(), "root")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, backend.URL+"/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := HTTPClient(nil).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	root.End()
+	shutdown()
+
+	spans := c.byName()
+	client, server := spans["GET"], spans["GET /foo"]
+	if client.ParentSpanID != spans["root"].SpanID {
+		t.Errorf("client span not child of root: %v", client)
+	}
+	if server.TraceID != client.TraceID || server.ParentSpanID != client.SpanID {
+		t.Errorf("server span not child of client span, server: %v, client: %v", server, client)
+	}
+	if want := "00-" + client.TraceID + "-" + client.SpanID + "-01"; received != want {
+		t.Errorf("unexpected traceparent, got %q, want %q", received, want)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	for _, tbl := range []struct {
+		in   string
+		want bool
+	}{
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", true},
+		// Future versions may have additional fields.
+		{"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra", true},
+		{"", false},
+		{"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", false},
+		{"00-00000000000000000000000000000000-b7ad6b7169203331-01", false},
+		{"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01", false},
+		{"00-0af7651916cd43dd8448eb211c80319x-b7ad6b7169203331-01", false},
+		{"00_0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", false},
+	} {
+		span, ok := parseTraceparent(tbl.in)
+		if ok != tbl.want {
+			t.Errorf("%q: got %v, want %v", tbl.in, ok, tbl.want)
+			continue
+		}
+		if ok && tbl.in[:2] == "00" && span.traceparent() != tbl.in {
+			t.Errorf("%q: round trip failed, got %q", tbl.in, span.traceparent())
+		}
+	}
+}
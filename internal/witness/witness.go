@@ -4,11 +4,12 @@ import (
 	"context"
 	"sync"
 
+	"sigsum.org/log-go/internal/logging"
+	"sigsum.org/log-go/internal/tracing"
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/checkpoint"
 	"sigsum.org/sigsum-go/pkg/client"
 	"sigsum.org/sigsum-go/pkg/crypto"
-	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/policy"
 	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/types"
@@ -26,9 +27,15 @@ type witness struct {
 	prevError error
 }
 
+// Queries are bounded by the deadline of the rotation context, so no
+// client timeout is needed.
 func newWitness(w *policy.Entity) *witness {
 	return &witness{
-		client:   client.New(client.Config{URL: w.URL, UserAgent: "Sigsum log-go server"}),
+		client: client.New(client.Config{
+			URL:        w.URL,
+			UserAgent:  "Sigsum log-go server",
+			HTTPClient: tracing.HTTPClient(logging.HTTPClient(nil)),
+		}),
 		entity:   *w,
 		keyHash:  crypto.HashBytes(w.PublicKey[:]),
 		prevSize: 0,
@@ -42,6 +49,14 @@ type cosignatureItem struct {
 }
 
 func (w *witness) getCosignature(ctx context.Context, cp *checkpoint.Checkpoint, getConsistencyProof GetConsistencyProofFunc) (cosignatureItem, error) {
+	ctx, span := tracing.Start(ctx, "witness.AddCheckpoint", "witness_url", w.entity.URL, "size", cp.Size)
+	defer span.End()
+	item, err := w.addCheckpoint(ctx, cp, getConsistencyProof)
+	span.SetError(err)
+	return item, err
+}
+
+func (w *witness) addCheckpoint(ctx context.Context, cp *checkpoint.Checkpoint, getConsistencyProof GetConsistencyProofFunc) (cosignatureItem, error) {
 	freshOldSize := false
 	for {
 		proof, err := getConsistencyProof(ctx, &requests.ConsistencyProof{
@@ -100,9 +115,24 @@ func NewCosignatureCollector(logPublicKey *crypto.PublicKey, witnesses []policy.
 	return &collector
 }
 
+// Restore sets the previous size for each witness that has cosigned
+// the given tree head, e.g., as restored at startup. This avoids an
+// initial round trip to learn the size from each witness.
+// Must not be called concurrently with GetCosignatures.
+func (c *CosignatureCollector) Restore(cth *types.CosignedTreeHead) {
+	for _, w := range c.witnesses {
+		if _, ok := cth.Cosignatures[w.keyHash]; ok {
+			w.prevSize = cth.Size
+		}
+	}
+}
+
 // Queries all witnesses in parallel, blocks until we have result or error from each of them.
 // Must not be concurrently called.
 func (c *CosignatureCollector) GetCosignatures(ctx context.Context, sth *types.SignedTreeHead) map[crypto.Hash]types.Cosignature {
+	ctx, span := tracing.Start(ctx, "witness.GetCosignatures", "witnesses", len(c.witnesses))
+	defer span.End()
+
 	cp := checkpoint.Checkpoint{
 		SignedTreeHead: *sth,
 		Origin:         c.origin,
@@ -127,13 +157,13 @@ func (c *CosignatureCollector) GetCosignatures(ctx context.Context, sth *types.S
 			// interesting, and logged at DEBUG level.
 			if err != nil {
 				if w.prevError == nil || (api.ErrorStatusCode(err) != api.ErrorStatusCode(w.prevError)) {
-					log.Info("querying witness %q failed: %v", w.entity.URL, err)
+					logging.Info(ctx, "querying witness failed", "witness_url", w.entity.URL, "error", err)
 				} else {
-					log.Debug("querying witness %q failed: %v", w.entity.URL, err)
+					logging.Debug(ctx, "querying witness failed", "witness_url", w.entity.URL, "error", err)
 				}
 			} else {
 				if w.prevError != nil {
-					log.Info("querying witness %q succeeded, previous attempt failed: %v", w.entity.URL, w.prevError)
+					logging.Info(ctx, "querying witness succeeded, previous attempt failed", "witness_url", w.entity.URL, "error", w.prevError)
 				}
 				ch <- cs
 			}
@@ -148,5 +178,6 @@ func (c *CosignatureCollector) GetCosignatures(ctx context.Context, sth *types.S
 		// TODO: Check that cosignature timestamp is reasonable?
 		cosignatures[i.keyHash] = i.cs
 	}
+	span.SetAttributes("cosignatures", len(cosignatures))
 	return cosignatures
 }
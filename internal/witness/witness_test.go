@@ -175,6 +175,23 @@ func TestGetCosignatures(t *testing.T) {
 	}
 }
 
+func TestRestore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	_, _, w1 := testWitness(t, ctrl)
+	_, _, w2 := testWitness(t, ctrl)
+	collector := CosignatureCollector{witnesses: []*witness{w1, w2}}
+	collector.Restore(&types.CosignedTreeHead{
+		SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 7}},
+		Cosignatures:   map[crypto.Hash]types.Cosignature{w1.keyHash: types.Cosignature{}},
+	})
+	if got, want := w1.prevSize, uint64(7); got != want {
+		t.Errorf("unexpected size for witness with cosignature, got %d, want %d", got, want)
+	}
+	if got, want := w2.prevSize, uint64(0); got != want {
+		t.Errorf("unexpected size for witness without cosignature, got %d, want %d", got, want)
+	}
+}
+
 func mustKeyPair(t *testing.T) (crypto.PublicKey, crypto.Signer) {
 	t.Helper()
 	pub, signer, err := crypto.NewKeyPair()